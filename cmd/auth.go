@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/config"
+	"github.com/XiaoMengXinX/sp-dl-go/credential"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"github.com/XiaoMengXinX/sp-dl-go/token"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	passphraseEnv  = "SPDL_PASSPHRASE"
+	spDcEnv        = "SPDL_SP_DC"
+	accessTokenEnv = "SPDL_ACCESS_TOKEN"
+)
+
+// stdin is shared by everything reading lines from standard input, since a
+// reader of its own would buffer, and lose, the lines after the first.
+var stdin = bufio.NewReader(os.Stdin)
+
+// authFlags are the flags shared by every command that talks to Spotify.
+type authFlags struct {
+	fs                 *flag.FlagSet
+	config             *string
+	profile            *string
+	credentials        *string
+	encryptCredentials *bool
+	spDcFile           *string
+	spDcStdin          *bool
+	nonInteractive     *bool
+	refreshMargin      *time.Duration
+	tokenFile          *string
+	tokenCommand       *string
+	debug              *bool
+	logFormat          *string
+	logOutput          *string
+	logMaxSize         *int
+	logMaxBackups      *int
+
+	// logTarget and logFile are the log output set up last by setupLogging.
+	logTarget string
+	logFile   *log.RotatingFile
+}
+
+func addAuthFlags(fs *flag.FlagSet) *authFlags {
+	return &authFlags{
+		fs:                 fs,
+		config:             fs.String("c", "config.json", "Path to config file. Can also be set with $SPDL_CONFIG."),
+		profile:            fs.String("profile", "", "Name of the config profile to use. Defaults to the default-profile of the config file."),
+		credentials:        fs.String("credentials", "credentials.json", "Path to credential file"),
+		encryptCredentials: fs.Bool("encrypt-credentials", false, "Encrypt the credential file with a passphrase, read from $SPDL_PASSPHRASE or prompted for."),
+		spDcFile:           fs.String("sp-dc-file", "", "Read the sp_dc cookie from this file. The cookie can also be set with $SPDL_SP_DC."),
+		spDcStdin:          fs.Bool("sp-dc-stdin", false, "Read the sp_dc cookie from the first line of stdin."),
+		nonInteractive:     fs.Bool("non-interactive", false, "Never prompt on the terminal; fail if the cookie or passphrase is missing."),
+		refreshMargin:      fs.Duration("token-refresh-margin", time.Minute, "Renew the access token this long before it expires."),
+		tokenFile:          fs.String("token-file", "", "Read the access token from this file instead of using the sp_dc cookie. A static token can also be set with $SPDL_ACCESS_TOKEN."),
+		tokenCommand:       fs.String("token-command", "", "Run this command and read the access token from its output instead of using the sp_dc cookie."),
+		debug:              fs.Bool("debug", false, "Print debug information. Use this to enable more detailed logging for troubleshooting."),
+		logFormat:          fs.String("log-format", log.FormatText, "Log format: text or json."),
+		logOutput:          fs.String("log-output", "stdout", "Where to write logs: stdout, stderr or the path of a log file."),
+		logMaxSize:         fs.Int("log-max-size", 10, "Rotate the log file once it grows past this many megabytes."),
+		logMaxBackups:      fs.Int("log-max-backups", 3, "Number of rotated log files to keep."),
+	}
+}
+
+// apply fills the flags of the command not given on the command line from
+// the environment and the config file, then points the token manager of sp
+// at the configured credential file and hands it a cookie from the
+// environment, a file or stdin if one was given.
+func (a *authFlags) apply(sp *spotify.Downloader) error {
+	if err := setFromEnv(a.fs); err != nil {
+		return err
+	}
+	if err := a.setupLogging(); err != nil {
+		return err
+	}
+
+	cm := sp.TokenManager.ConfigManager
+	cm.SetConfigPath(*a.config).SetProfile(*a.profile).Initialize()
+	log.Infof("Set Config Path: %s", *a.config)
+
+	if err := cm.ReadConfig(); err != nil {
+		log.Warnf("Failed to read config: %v", err)
+	}
+	profile, err := cm.Profile()
+	if err != nil {
+		return err
+	}
+	if name := cm.ProfileName(); name != "" {
+		log.Infof("Using profile: %s", name)
+	}
+	if err := setFromConfig(a.fs, profile.Options, cm.Get().Options); err != nil {
+		return err
+	}
+	if err := a.setupLogging(); err != nil {
+		return err
+	}
+
+	if !isFlagSet(a.fs, "credentials") {
+		*a.credentials = config.DefaultCredentialPath(cm.ProfileName())
+	}
+	store, err := a.credentialStore()
+	if err != nil {
+		return err
+	}
+	sp.TokenManager.CredentialStore = store
+	log.Infof("Set Credential Path: %s", *a.credentials)
+
+	spDc, err := a.spDc()
+	if err != nil {
+		return err
+	}
+	sp.TokenManager.SpDc = spDc
+	sp.TokenManager.NonInteractive = *a.nonInteractive
+	sp.TokenManager.RefreshMargin = *a.refreshMargin
+
+	switch args := strings.Fields(*a.tokenCommand); {
+	case len(args) > 0:
+		sp.SetTokenSource(token.CommandTokenSource(args[0], args[1:]...))
+		log.Infoln("Using access tokens from token command")
+	case *a.tokenFile != "":
+		sp.SetTokenSource(token.FileTokenSource(*a.tokenFile))
+		log.Infof("Using access tokens from file: %s", *a.tokenFile)
+	case os.Getenv(accessTokenEnv) != "":
+		sp.SetTokenSource(token.StaticTokenSource(os.Getenv(accessTokenEnv)))
+		log.Infof("Using access token from $%s", accessTokenEnv)
+	}
+	sp.SetAcceptLanguage(cm.AcceptLanguage()...)
+	return nil
+}
+
+// check reports values apply would reject.
+func (a *authFlags) check() error {
+	switch *a.logFormat {
+	case log.FormatText, log.FormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", *a.logFormat, log.FormatText, log.FormatJSON)
+	}
+}
+
+// setupLogging applies the log flags. It runs again once the config file
+// has been read, and only replaces the log output if it changed.
+func (a *authFlags) setupLogging() error {
+	if *a.debug {
+		log.SetLevel(log.LevelDebug)
+	}
+
+	target := fmt.Sprintf("%s %s %d %d", *a.logFormat, *a.logOutput, *a.logMaxSize, *a.logMaxBackups)
+	if target == a.logTarget {
+		return nil
+	}
+	a.logTarget = target
+
+	var w io.Writer
+	var logFile *log.RotatingFile
+	switch *a.logOutput {
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		var err error
+		logFile, err = log.NewRotatingFile(*a.logOutput, int64(*a.logMaxSize)<<20, *a.logMaxBackups)
+		if err != nil {
+			return err
+		}
+		w = logFile
+	}
+	if err := log.Configure(*a.logFormat, w); err != nil {
+		if logFile != nil {
+			_ = logFile.Close()
+		}
+		return err
+	}
+	if a.logFile != nil {
+		_ = a.logFile.Close()
+	}
+	a.logFile = logFile
+	return nil
+}
+
+func (a *authFlags) credentialStore() (credential.Store, error) {
+	if !*a.encryptCredentials {
+		return credential.NewFileStore(*a.credentials), nil
+	}
+	passphrase := os.Getenv(passphraseEnv)
+	if passphrase == "" {
+		if *a.nonInteractive {
+			return nil, fmt.Errorf("$%s is required to read encrypted credentials in non-interactive mode", passphraseEnv)
+		}
+		var err error
+		if passphrase, err = readSecret("Credential passphrase: "); err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+	}
+	return credential.NewEncryptedStore(*a.credentials, passphrase), nil
+}
+
+// readSecret prompts for a secret on the terminal without echoing it, or
+// reads the first line of stdin if it is not a terminal. Spaces are kept.
+func readSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Print(prompt)
+		secret, err := term.ReadPassword(fd)
+		fmt.Println()
+		return string(secret), err
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (a *authFlags) spDc() (string, error) {
+	switch {
+	case *a.spDcStdin:
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read sp_dc cookie from stdin: %w", err)
+		}
+		return strings.TrimSpace(line), nil
+	case *a.spDcFile != "":
+		data, err := os.ReadFile(*a.spDcFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read sp_dc cookie file: %w", err)
+		}
+		spDc := strings.TrimSpace(string(data))
+		if spDc == "" {
+			return "", errors.New("sp_dc cookie file is empty")
+		}
+		return spDc, nil
+	default:
+		return strings.TrimSpace(os.Getenv(spDcEnv)), nil
+	}
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
@@ -9,14 +9,39 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "info":
+			runInfo(os.Args[2:])
+			return
+		case "login":
+			runLogin(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "watch":
+			runWatch(os.Args[2:])
+			return
+		case "sync":
+			runSync(os.Args[2:])
+			return
+		case "manifest":
+			runManifest(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		}
+	}
+
 	showHelp := flag.Bool("help", false, "Show this help message.")
 	id := flag.String("id", "", "Spotify URL/URI/ID (required). Example usage: -id https://open.spotify.com/track/4jTrKMoc44RYZsoFsIlQev")
-	quality := flag.String("quality", spotify.Quality128MP4Dual, "Quality level. Options: MP4_128, MP4_128_DUAL, MP4_256, MP4_256_DUAL, OGG_VORBIS_320, OGG_VORBIS_160, OGG_VORBIS_96")
-	output := flag.String("output", "./output", "Output path.")
-	config := flag.String("c", "config.json", "Path to config file")
-	debug := flag.Bool("debug", false, "Print debug information. Use this to enable more detailed logging for troubleshooting.")
-	isConvertToMP3 := flag.Bool("mp3", false, "Convert downloaded music to mp3 format")
-	isSkipAddingMetadata := flag.Bool("no-metadata", false, "Skip adding metadata to downloaded files.")
+	download := addDownloadFlags(flag.CommandLine)
+	auth := addAuthFlags(flag.CommandLine)
 
 	flag.Parse()
 
@@ -31,31 +56,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	if *debug {
-		log.SetLevel(log.LevelDebug)
-	}
-
 	sp := spotify.NewDownloader()
 
-	sp.TokenManager.ConfigManager.SetConfigPath(*config)
-	log.Infof("Set Config Path: %s", *config)
-
-	sp.SetOutputPath(*output)
-	log.Infof("Set Output path: %s", *output)
-
-	if err := sp.SetQuality(*quality); err != nil {
+	if err := auth.apply(sp); err != nil {
 		log.Fatalf("Error: %v", err)
 	}
-	log.Infof("Set quality level: %s", *quality)
-
-	if *isConvertToMP3 {
-		sp.ConvertToMP3(*isConvertToMP3)
-		log.Infoln("Downloaded music will be converted to mp3")
-	}
 
-	if *isSkipAddingMetadata {
-		sp.SkipAddingMetadata(*isSkipAddingMetadata)
-		log.Infoln("Skip adding metadata to downloaded files")
+	if err := download.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
 	}
 
 	log.Infof("Initializing Downloader")
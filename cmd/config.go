@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/config"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"os"
+	"sort"
+	"strings"
+)
+
+const configUsage = `Usage of config:
+  config profiles list [-c config.json]
+  config profiles add [-c config.json] [-default] [-credentials path] [-accept-language en,ja] [-quality MP4_256] [-output-template "{name} - {artist}"] <name>
+  config profiles remove [-c config.json] <name>
+  config validate [-c config.json]`
+
+func runConfig(args []string) {
+	if len(args) > 0 && args[0] == "validate" {
+		runConfigValidate(args[1:])
+		return
+	}
+	if len(args) < 2 || args[0] != "profiles" {
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+
+	switch args[1] {
+	case "list":
+		runProfilesList(args[2:])
+	case "add":
+		runProfilesAdd(args[2:])
+	case "remove":
+		runProfilesRemove(args[2:])
+	default:
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+}
+
+func loadConfig(path string) config.Data {
+	conf, err := config.NewConfigManager().SetConfigPath(path).Initialize().ReadAndGet()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	return conf
+}
+
+func runProfilesList(args []string) {
+	fs := flag.NewFlagSet("config profiles list", flag.ExitOnError)
+	configPath := fs.String("c", "config.json", "Path to config file")
+	_ = fs.Parse(args)
+
+	conf := loadConfig(*configPath)
+	names := make([]string, 0, len(conf.Profiles))
+	for name := range conf.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := conf.Profiles[name]
+		marker := " "
+		if name == conf.DefaultProfile {
+			marker = "*"
+		}
+		credentials := profile.Credentials
+		if credentials == "" {
+			credentials = config.DefaultCredentialPath(name)
+		}
+		fmt.Printf("%s %s\tcredentials=%s quality=%s output-template=%q accept-language=%s\n",
+			marker, name, credentials, profile.Quality, profile.OutputTemplate, strings.Join(profile.AcceptLanguage, ","))
+	}
+}
+
+func runProfilesAdd(args []string) {
+	fs := flag.NewFlagSet("config profiles add", flag.ExitOnError)
+	configPath := fs.String("c", "config.json", "Path to config file")
+	isDefault := fs.Bool("default", false, "Make this the default profile.")
+	credentials := fs.String("credentials", "", "Path to the credential file of the profile.")
+	acceptLanguage := fs.String("accept-language", "", "Comma-separated languages of metadata, e.g. en,ja")
+	quality := fs.String("quality", "", "Default quality level of the profile.")
+	outputTemplate := fs.String("output-template", "", "Default output template of the profile.")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+	name := fs.Arg(0)
+
+	if *quality != "" {
+		if err := spotify.NewDownloader().SetQuality(*quality); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	cm := config.NewConfigManager().SetConfigPath(*configPath)
+	err := cm.Update(func(conf *config.Data) error {
+		if conf.Profiles == nil {
+			conf.Profiles = make(map[string]config.Profile)
+		}
+		profile := conf.Profiles[name]
+		if *credentials != "" {
+			profile.Credentials = *credentials
+		}
+		if *acceptLanguage != "" {
+			profile.AcceptLanguage = strings.Split(*acceptLanguage, ",")
+		}
+		if *quality != "" {
+			profile.Quality = *quality
+		}
+		if *outputTemplate != "" {
+			profile.OutputTemplate = *outputTemplate
+		}
+		conf.Profiles[name] = profile
+		if *isDefault {
+			conf.DefaultProfile = name
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	log.Infof("Saved profile: %s", name)
+}
+
+func runProfilesRemove(args []string) {
+	fs := flag.NewFlagSet("config profiles remove", flag.ExitOnError)
+	configPath := fs.String("c", "config.json", "Path to config file")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println(configUsage)
+		os.Exit(1)
+	}
+	name := fs.Arg(0)
+
+	cm := config.NewConfigManager().SetConfigPath(*configPath)
+	err := cm.Update(func(conf *config.Data) error {
+		if _, ok := conf.Profiles[name]; !ok {
+			return fmt.Errorf("profile %q not found", name)
+		}
+		delete(conf.Profiles, name)
+		if conf.DefaultProfile == name {
+			conf.DefaultProfile = ""
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	log.Infof("Removed profile: %s", name)
+}
+
+// runConfigValidate reports keys of the config file that no setting reads
+// and option values that would be rejected, for the top level and for every
+// profile with the top-level values it inherits.
+func runConfigValidate(args []string) {
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configPath := fs.String("c", "config.json", "Path to config file")
+	_ = fs.Parse(args)
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	unknown, err := config.UnknownKeys(data)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	var conf config.Data
+	if err := json.Unmarshal(data, &conf); err != nil {
+		log.Fatalf("Error: unable to parse json config file: %v", err)
+	}
+
+	var problems []string
+	if conf.Version > config.CurrentVersion {
+		problems = append(problems, fmt.Sprintf("version %d is newer than the supported version %d", conf.Version, config.CurrentVersion))
+	}
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("unknown key %q", key))
+	}
+	if conf.DefaultProfile != "" {
+		if _, ok := conf.Profiles[conf.DefaultProfile]; !ok {
+			problems = append(problems, fmt.Sprintf("default-profile %q not found in profiles", conf.DefaultProfile))
+		}
+	}
+
+	if err := checkOptions(conf.Options); err != nil {
+		problems = append(problems, strings.Split(err.Error(), "\n")...)
+	}
+	names := make([]string, 0, len(conf.Profiles))
+	for name := range conf.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := checkOptions(conf.Profiles[name].Options, conf.Options); err != nil {
+			for _, problem := range strings.Split(err.Error(), "\n") {
+				problems = append(problems, fmt.Sprintf("profile %s: %s", name, problem))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", *configPath, problem)
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("%s: OK\n", *configPath)
+}
+
+// checkOptions applies layers to the flags of the download command the way
+// a run would and reports the values that are rejected.
+func checkOptions(layers ...config.Options) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	download := addDownloadFlags(fs)
+	sync := addSyncFlags(fs)
+	watch := addWatchFlags(fs)
+	serve := addServeFlags(fs)
+	auth := addAuthFlags(fs)
+	return errors.Join(setFromConfig(fs, layers...), download.check(), sync.check(), watch.check(), serve.check(), auth.check())
+}
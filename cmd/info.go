@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"os"
+)
+
+func runInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	id := fs.String("id", "", "Spotify track URL/URI/ID (required).")
+	auth := addAuthFlags(fs)
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		fmt.Println("Error: -id is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	trackID, idType, err := spotify.GetIDType(*id)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if idType != spotify.TRACK {
+		log.Fatalf("Error: info only supports tracks, got %s", idType)
+	}
+
+	sp := spotify.NewDownloader()
+	if err := auth.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	sp.Authenticate()
+
+	info, err := sp.WebAPIGetTrackInfo(trackID)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	data, _ := json.MarshalIndent(info, "", "  ")
+	fmt.Println(string(data))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"os"
+	"time"
+)
+
+func runLogin(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	auth := addAuthFlags(fs)
+	_ = fs.Parse(args)
+
+	sp := spotify.NewDownloader()
+	if err := auth.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	sp.TokenManager.ConfigManager.Initialize()
+
+	spDc := sp.TokenManager.SpDc
+	if spDc == "" {
+		if *auth.nonInteractive {
+			log.Fatalln("Error: no sp_dc cookie given")
+		}
+		fmt.Print("sp_dc: ")
+		_, _ = fmt.Scanln(&spDc)
+	}
+	if spDc == "" {
+		fmt.Println("Error: sp_dc cookie is required")
+		os.Exit(1)
+	}
+
+	if err := sp.TokenManager.Login(spDc); err != nil {
+		log.Fatalf("Login failed: %v", err)
+	}
+	expire := sp.TokenManager.AccessTokenExpiry()
+	log.Infof("Login successful, access token valid until %s", expire.Format(time.DateTime))
+}
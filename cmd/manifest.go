@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const manifestUsage = `Usage of manifest:
+  manifest validate <folder>...`
+
+func runManifest(args []string) {
+	if len(args) < 2 || args[0] != "validate" {
+		fmt.Println(manifestUsage)
+		os.Exit(1)
+	}
+	runManifestValidate(args[1:])
+}
+
+// runManifestValidate checks every folder with a manifest under the given
+// folders, prints the files that are missing, modified or not listed, and
+// exits with 1 if any are found.
+func runManifestValidate(folders []string) {
+	var manifests, problems int
+	for _, folder := range folders {
+		err := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			found, err := spotify.ValidateManifest(path)
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			manifests++
+			for _, problem := range found {
+				fmt.Printf("%s: %s\n", problem.Path, problem.Problem)
+			}
+			problems += len(found)
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	switch {
+	case manifests == 0:
+		fmt.Printf("No %s found\n", spotify.ManifestName)
+		os.Exit(1)
+	case problems > 0:
+		fmt.Printf("%d problem(s) in %d folder(s)\n", problems, manifests)
+		os.Exit(1)
+	}
+	fmt.Printf("%d folder(s) OK\n", manifests)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/config"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/notify"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// smtpPasswordEnv holds the password of SMTP notification sinks that do not
+// have one in their URL.
+const smtpPasswordEnv = "SPDL_SMTP_PASSWORD"
+
+// Flags that select what to run rather than how, and so are not read from
+// the environment.
+var envExcluded = map[string]bool{
+	"help": true,
+	"id":   true,
+}
+
+// downloadFlags are the options of the download command.
+type downloadFlags struct {
+	quality              *string
+	output               *string
+	outputTemplate       *string
+	filenamePolicy       *string
+	isConvertToMP3       *bool
+	convert              *string
+	isSkipAddingMetadata *bool
+	replayGain           *bool
+	coverSize            *string
+	coverCache           *string
+	coverFiles           *string
+	cacheDir             *string
+	cacheTTL             *time.Duration
+	feedBaseURL          *string
+	verify               *bool
+	verifyTolerance      *time.Duration
+	retries              *int
+	archive              *string
+	manifest             *bool
+	dedup                *string
+	libraryIndex         *string
+	hooks                *stringList
+	notify               *stringList
+	notifyOn             *string
+	notifyRetries        *int
+	notifyTemplate       *string
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func addDownloadFlags(fs *flag.FlagSet) *downloadFlags {
+	hooks := new(stringList)
+	fs.Var(hooks, "hook", "Run a command, with the item as JSON on stdin, at a stage: after-download, after-tagging, item-failed or after-batch. Options may follow the stage: timeout=<duration> (default 1m) and fail to mark the item failed if the command fails, e.g. after-tagging,timeout=5m,fail:/usr/local/bin/rescan. Repeatable.")
+	notifySinks := new(stringList)
+	fs.Var(notifySinks, "notify", "Send notifications to a webhook (http:// or https:// URL, posted as JSON) or by email (smtp://user@host:587?from=...&to=..., or smtps:// for implicit TLS; the password can also be set with $"+smtpPasswordEnv+"). Repeatable.")
+	return &downloadFlags{
+		hooks:                hooks,
+		notify:               notifySinks,
+		notifyOn:             fs.String("notify-on", spotify.HookItemFailed+","+spotify.HookAfterBatch, "Comma-separated events to notify of: item-failed and after-batch."),
+		notifyRetries:        fs.Int("notify-retries", notify.DefaultRetries, "How many more times to send a notification that failed, waiting twice as long each time."),
+		notifyTemplate:       fs.String("notify-template", "", "File with a text/template redefining the \"subject\" or \"body\" of notifications."),
+		dedup:                fs.String("dedup", spotify.DedupOff, "What to do with a recording already in the library index, found by track ID, ISRC or file ID at equal or better quality: off (download again), hardlink, symlink or reference (list it in the m3u8 playlist written for albums and playlists)."),
+		libraryIndex:         fs.String("library-index", "", "Library index file for -dedup. Defaults to "+spotify.DefaultLibraryIndexName+" in the output folder."),
+		quality:              fs.String("quality", spotify.Quality128MP4Dual, "Quality level. Options: MP4_128, MP4_128_DUAL, MP4_256, MP4_256_DUAL, OGG_VORBIS_320, OGG_VORBIS_160, OGG_VORBIS_96"),
+		output:               fs.String("output", "./output", "Output path."),
+		outputTemplate:       fs.String("output-template", spotify.DefaultOutputTemplate, "Name of downloaded files. Placeholders: {name}, {artist}, {album}, {track}, {disc}, {id}; slashes create folders."),
+		filenamePolicy:       fs.String("filename-policy", "", "Characters allowed in file names: posix (all but slashes), windows (safe on Windows and SMB shares) or ascii (windows, transliterated to ASCII). Defaults to windows on Windows and posix elsewhere."),
+		isConvertToMP3:       fs.Bool("mp3", false, "Convert downloaded music to mp3 format. Same as -convert mp3."),
+		convert:              fs.String("convert", "", "Convert downloaded files to mp3, aac, opus or flac, with options after a colon: bitrate (kbit/s), vbr (mp3 only, 0-9), rate (Hz) and channels, e.g. mp3:vbr=0 or opus:bitrate=128,channels=2"),
+		isSkipAddingMetadata: fs.Bool("no-metadata", false, "Skip adding metadata to downloaded files."),
+		replayGain:           fs.Bool("replaygain", false, "Measure loudness with ffmpeg and write ReplayGain 2.0 track tags, plus album tags when downloading a whole album."),
+		coverSize:            fs.String("cover-size", spotify.CoverSizeLargest, "Cover size to embed: largest, a width (e.g. 640) or a maximum dimension (e.g. max:1000)."),
+		coverCache:           fs.String("cover-cache", "", "Folder for caching downloaded covers across runs. Defaults to a folder in the user cache directory."),
+		coverFiles:           fs.String("cover-files", "", "Comma-separated file names to save the cover as next to downloaded files, e.g. cover.jpg,folder.jpg"),
+		cacheDir:             fs.String("cache-dir", "", "Folder for caching track and album metadata across runs. Metadata is only cached in memory if empty."),
+		cacheTTL:             fs.Duration("cache-ttl", 24*time.Hour, "How long cached metadata stays valid, in memory and in -cache-dir."),
+		feedBaseURL:          fs.String("feed-base-url", "", "Base URL of episode links in the podcast feed written for shows."),
+		verify:               fs.Bool("verify", false, "Check downloaded files with ffprobe: the file must be readable, have an audio stream and match the length of the track. Files are checked before the after-download hooks and again after tagging."),
+		verifyTolerance:      fs.Duration("verify-tolerance", spotify.DefaultVerifyTolerance, "How far the length of a verified file may be from the length of the track."),
+		retries:              fs.Int("retries", 2, "How many more times to download an item that fails verification."),
+		archive:              fs.String("archive", "", "Record downloaded items in this file, to check them later with the verify command."),
+		manifest:             fs.Bool("manifest", false, "Keep a manifest.json with the SHA-256 checksum of every downloaded file in each output folder, to check them later with the manifest validate command."),
+	}
+}
+
+// check reports values the Downloader would reject.
+func (f *downloadFlags) check() error {
+	sp := spotify.NewDownloader()
+	errs := []error{
+		sp.SetQuality(*f.quality),
+		sp.SetOutputTemplate(*f.outputTemplate),
+		sp.SetFilenamePolicy(*f.filenamePolicy),
+		sp.SetCoverSize(*f.coverSize),
+		sp.SetConvert(*f.convert),
+		sp.SetDedupPolicy(*f.dedup),
+	}
+	if *f.retries < 0 {
+		errs = append(errs, errors.New("retries must not be negative"))
+	}
+	for _, spec := range *f.hooks {
+		_, err := spotify.ParseHook(spec)
+		errs = append(errs, err)
+	}
+	_, err := f.notifier()
+	errs = append(errs, err)
+	return errors.Join(errs...)
+}
+
+// notifier returns the Notifier set up by the notify flags, or nil if no
+// sink is given.
+func (f *downloadFlags) notifier() (*notify.Notifier, error) {
+	n := notify.New()
+	errs := []error{
+		n.SetStages(strings.Split(*f.notifyOn, ",")...),
+		n.SetRetries(*f.notifyRetries, notify.DefaultRetryDelay),
+	}
+	if *f.notifyTemplate != "" {
+		text, err := os.ReadFile(*f.notifyTemplate)
+		if err == nil {
+			err = n.SetTemplate(string(text))
+		}
+		errs = append(errs, err)
+	}
+	for _, spec := range *f.notify {
+		sink, err := notify.ParseSink(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		switch sink := sink.(type) {
+		case *notify.Webhook:
+			// The URL of a webhook is often its only credential
+			log.AddSecret(sink.URL)
+		case *notify.SMTP:
+			if sink.Password == "" {
+				sink.Password = os.Getenv(smtpPasswordEnv)
+			}
+			if sink.Password != "" {
+				log.AddSecret(sink.Password)
+			}
+		}
+		n.AddSink(sink)
+	}
+	if err := errors.Join(errs...); err != nil || len(*f.notify) == 0 {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (f *downloadFlags) apply(sp *spotify.Downloader) error {
+	sp.SetOutputPath(*f.output)
+	log.Infof("Set Output path: %s", *f.output)
+
+	if err := sp.SetOutputTemplate(*f.outputTemplate); err != nil {
+		return err
+	}
+	log.Infof("Set Output template: %s", *f.outputTemplate)
+
+	if err := sp.SetFilenamePolicy(*f.filenamePolicy); err != nil {
+		return err
+	}
+	if *f.filenamePolicy != "" {
+		log.Infof("Set filename policy: %s", *f.filenamePolicy)
+	}
+
+	if err := sp.SetQuality(*f.quality); err != nil {
+		return err
+	}
+	log.Infof("Set quality level: %s", *f.quality)
+
+	switch {
+	case *f.convert != "":
+		if err := sp.SetConvert(*f.convert); err != nil {
+			return err
+		}
+		log.Infof("Downloaded music will be converted to %s", *f.convert)
+	case *f.isConvertToMP3:
+		sp.ConvertToMP3(*f.isConvertToMP3)
+		log.Infoln("Downloaded music will be converted to mp3")
+	}
+
+	if *f.isSkipAddingMetadata {
+		sp.SkipAddingMetadata(*f.isSkipAddingMetadata)
+		log.Infoln("Skip adding metadata to downloaded files")
+	}
+
+	if *f.replayGain {
+		sp.ReplayGain(*f.replayGain)
+		log.Infoln("ReplayGain tags will be added to downloaded files")
+	}
+
+	if err := sp.SetCoverSize(*f.coverSize); err != nil {
+		return err
+	}
+
+	if *f.coverCache != "" {
+		sp.SetCoverCacheDir(*f.coverCache)
+		log.Infof("Set cover cache path: %s", *f.coverCache)
+	}
+
+	if *f.coverFiles != "" {
+		sp.SetCoverFiles(strings.Split(*f.coverFiles, ",")...)
+		log.Infof("Save covers as: %s", *f.coverFiles)
+	}
+
+	sp.SetMetadataCache(*f.cacheDir, *f.cacheTTL)
+	if *f.cacheDir != "" {
+		log.Infof("Set metadata cache path: %s (TTL %s)", *f.cacheDir, *f.cacheTTL)
+	}
+
+	if *f.feedBaseURL != "" {
+		sp.SetFeedBaseURL(*f.feedBaseURL)
+		log.Infof("Set podcast feed base URL: %s", *f.feedBaseURL)
+	}
+
+	if *f.verify {
+		sp.Verify(*f.verify).SetVerifyTolerance(*f.verifyTolerance).SetRetries(*f.retries)
+		log.Infof("Downloaded files will be verified (tolerance %s, %d retries)", *f.verifyTolerance, *f.retries)
+	}
+
+	if *f.archive != "" {
+		sp.SetArchive(*f.archive)
+		log.Infof("Set archive path: %s", *f.archive)
+	}
+
+	if *f.manifest {
+		sp.WriteManifests(*f.manifest)
+		log.Infoln("Checksums of downloaded files will be written to manifests")
+	}
+
+	if err := sp.SetDedupPolicy(*f.dedup); err != nil {
+		return err
+	}
+	if *f.dedup != spotify.DedupOff {
+		sp.SetLibraryIndex(*f.libraryIndex)
+		log.Infof("Set dedup policy: %s", *f.dedup)
+	}
+
+	for _, spec := range *f.hooks {
+		hook, err := spotify.ParseHook(spec)
+		if err != nil {
+			return err
+		}
+		if err := sp.AddHook(hook); err != nil {
+			return err
+		}
+		log.Infof("Added %s hook: %s", hook.Stage, strings.Join(hook.Command, " "))
+	}
+
+	n, err := f.notifier()
+	if err != nil {
+		return err
+	}
+	if n != nil {
+		if err := n.Attach(sp); err != nil {
+			return err
+		}
+		log.Infof("Notifications of %s will be sent to %d sink(s)", *f.notifyOn, len(*f.notify))
+	}
+	return nil
+}
+
+// envName returns the environment variable overriding a flag, e.g.
+// SPDL_OUTPUT_TEMPLATE for -output-template.
+func envName(flagName string) string {
+	if flagName == "c" {
+		return "SPDL_CONFIG"
+	}
+	return "SPDL_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// setFromEnv sets the flags of fs not given on the command line from their
+// SPDL_* environment variables.
+func setFromEnv(fs *flag.FlagSet) error {
+	set := setFlags(fs)
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || envExcluded[f.Name] {
+			return
+		}
+		name := envName(f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value %q for $%s: %v", value, name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// setFromConfig sets the flags of fs that are still unset from the first of
+// layers that has a value for them, so that earlier layers take precedence.
+func setFromConfig(fs *flag.FlagSet, layers ...config.Options) error {
+	set := setFlags(fs)
+	var errs []error
+	for _, layer := range layers {
+		values := layer.Values()
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if set[name] || fs.Lookup(name) == nil {
+				continue
+			}
+			for _, value := range values[name] {
+				if err := fs.Set(name, value); err != nil {
+					errs = append(errs, fmt.Errorf("invalid value %q for %s: %v", value, name, err))
+				}
+			}
+			set[name] = true
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
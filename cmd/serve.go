@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/server"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long the serve command waits for open requests
+// when stopping.
+const shutdownTimeout = 10 * time.Second
+
+// serveFlags are the options of the serve command on top of the download
+// options.
+type serveFlags struct {
+	listen *string
+	token  *string
+	jobs   *string
+}
+
+func addServeFlags(fs *flag.FlagSet) *serveFlags {
+	return &serveFlags{
+		listen: fs.String("listen", "127.0.0.1:8080", "Address the API listens on."),
+		token:  fs.String("api-token", "", "Require clients to send this token as \"Authorization: Bearer <token>\". Can also be set with $SPDL_API_TOKEN."),
+		jobs:   fs.String("jobs", "jobs.json", "File keeping the job queue across restarts."),
+	}
+}
+
+func (f *serveFlags) check() error {
+	if _, _, err := net.SplitHostPort(*f.listen); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runServe serves the job API until interrupted, downloading submitted jobs
+// one at a time.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	download := addDownloadFlags(fs)
+	serve := addServeFlags(fs)
+	auth := addAuthFlags(fs)
+	_ = fs.Parse(args)
+
+	sp := spotify.NewDownloader()
+	if err := auth.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := download.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := serve.check(); err != nil {
+		log.Fatalf("Error: invalid listen address: %v", err)
+	}
+
+	log.Infof("Initializing Downloader")
+	sp.Initialize()
+
+	s, err := server.New(sp, *serve.jobs)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *serve.token == "" {
+		log.Warnf("No API token set, the API is open to anyone who can reach %s", *serve.listen)
+	}
+	s.SetToken(*serve.token)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	srv := &http.Server{Addr: *serve.listen, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Infof("Serving API on %s", *serve.listen)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalln(err)
+	}
+	<-done
+	log.Infoln("Stopped serving")
+}
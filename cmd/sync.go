@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"os"
+)
+
+// syncFlags are the options of the sync command on top of the download
+// options.
+type syncFlags struct {
+	removed *string
+	trash   *string
+}
+
+func addSyncFlags(fs *flag.FlagSet) *syncFlags {
+	return &syncFlags{
+		removed: fs.String("sync-removed", spotify.SyncTrash, "What to do with files of items removed from the collection: trash or delete."),
+		trash:   fs.String("trash", "", "Trash folder for -sync-removed trash. Defaults to "+spotify.DefaultTrashName+" in the output folder."),
+	}
+}
+
+func (f *syncFlags) check() error {
+	return spotify.NewDownloader().SetSyncRemoval(*f.removed, *f.trash)
+}
+
+func (f *syncFlags) apply(sp *spotify.Downloader) error {
+	return sp.SetSyncRemoval(*f.removed, *f.trash)
+}
+
+// runSync mirrors an album, playlist or show into the output folder and
+// prints what changed. It exits with 1 if any item failed.
+func runSync(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	id := fs.String("id", "", "Spotify album, playlist or show URL/URI/ID (required).")
+	download := addDownloadFlags(fs)
+	sync := addSyncFlags(fs)
+	auth := addAuthFlags(fs)
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		fmt.Println("Error: -id is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	sp := spotify.NewDownloader()
+	if err := auth.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := download.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := sync.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	log.Infof("Initializing Downloader")
+	sp.Initialize()
+
+	result, err := sp.Sync(*id)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, item := range result.Added {
+		fmt.Printf("+ %s\n", item.Path)
+	}
+	for _, item := range result.Removed {
+		if item.MovedTo != "" {
+			fmt.Printf("- %s (moved to %s)\n", item.Path, item.MovedTo)
+		} else {
+			fmt.Printf("- %s\n", item.Path)
+		}
+	}
+	for _, item := range result.Failed {
+		fmt.Printf("! %s: %s\n", item.ID, item.Error)
+	}
+	fmt.Printf("%d added, %d removed, %d unchanged, %d failed\n", len(result.Added), len(result.Removed), result.Unchanged, len(result.Failed))
+	if len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/config"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"os"
+)
+
+// runVerify checks every file recorded in an archive with ffprobe, the way
+// -verify checks a new download, and exits with 1 if any fails.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	configPath := fs.String("c", "config.json", "Path to config file. Can also be set with $SPDL_CONFIG.")
+	profileName := fs.String("profile", "", "Name of the config profile to use. Defaults to the default-profile of the config file.")
+	archive := fs.String("archive", "", "Archive file written by -archive (required).")
+	tolerance := fs.Duration("verify-tolerance", spotify.DefaultVerifyTolerance, "How far the length of a file may be from the length of the track.")
+	_ = fs.Parse(args)
+
+	if err := setFromEnv(fs); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if _, err := os.Stat(*configPath); err == nil {
+		cm := config.NewConfigManager().SetConfigPath(*configPath).SetProfile(*profileName)
+		if err := cm.ReadConfig(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		profile, err := cm.Profile()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if err := setFromConfig(fs, profile.Options, cm.Get().Options); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	if *archive == "" {
+		fmt.Println("Error: -archive is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	entries, err := spotify.ReadArchive(*archive)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	failed := 0
+	for _, entry := range entries {
+		problem := ""
+		if _, err := os.Stat(entry.Path); err != nil {
+			problem = "missing"
+		} else if err := spotify.VerifyFile(entry.Path, entry.DurationMS, *tolerance); err != nil {
+			var verifyErr *spotify.VerifyError
+			if !errors.As(err, &verifyErr) {
+				log.Fatalf("Error: %v", err)
+			}
+			problem = verifyErr.Reason
+		}
+		if problem != "" {
+			fmt.Printf("%s: %s %s: %s\n", entry.Path, entry.Type, entry.ID, problem)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d file(s) failed verification\n", failed, len(entries))
+		os.Exit(1)
+	}
+	fmt.Printf("%d file(s) OK\n", len(entries))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// watchFlags are the options of the watch command on top of the download
+// options.
+type watchFlags struct {
+	targets  *stringList
+	interval *time.Duration
+	state    *string
+}
+
+func addWatchFlags(fs *flag.FlagSet) *watchFlags {
+	targets := new(stringList)
+	fs.Var(targets, "watch", "Playlist, album, show or artist URL/URI to watch, optionally followed by @ and its own poll interval, e.g. https://open.spotify.com/playlist/...@30m. Repeatable.")
+	return &watchFlags{
+		targets:  targets,
+		interval: fs.Duration("interval", spotify.DefaultWatchInterval, "How often to poll watched collections without their own interval."),
+		state:    fs.String("watch-state", "", "File keeping what was downloaded and when to poll next across restarts. Defaults to "+spotify.DefaultWatchStateName+" in the output folder."),
+	}
+}
+
+func (f *watchFlags) parse() ([]spotify.WatchTarget, error) {
+	var targets []spotify.WatchTarget
+	var errs []error
+	for _, spec := range *f.targets {
+		target, err := spotify.ParseWatchTarget(spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if target.Interval == 0 {
+			target.Interval = *f.interval
+		}
+		targets = append(targets, target)
+	}
+	if *f.interval <= 0 {
+		errs = append(errs, errors.New("interval must be positive"))
+	}
+	return targets, errors.Join(errs...)
+}
+
+func (f *watchFlags) check() error {
+	_, err := f.parse()
+	return err
+}
+
+// runWatch polls the watched collections until interrupted, downloading
+// what was added to them.
+func runWatch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	download := addDownloadFlags(fs)
+	watch := addWatchFlags(fs)
+	auth := addAuthFlags(fs)
+	_ = fs.Parse(args)
+
+	sp := spotify.NewDownloader()
+	if err := auth.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := download.apply(sp); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	targets, err := watch.parse()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if len(targets) == 0 {
+		fmt.Println("Error: at least one -watch is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	sp.SetWatchState(*watch.state)
+
+	log.Infof("Initializing Downloader")
+	sp.Initialize()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Infof("Watching %d collection(s)", len(targets))
+	if err := sp.Watch(ctx, targets); err != nil {
+		log.Fatalln(err)
+	}
+	log.Infoln("Stopped watching")
+}
@@ -4,64 +4,231 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
 	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"log/slog"
 	"os"
+	"reflect"
 )
 
+// CurrentVersion is the layout version of config files written by this
+// version. Older files are migrated when read.
+const CurrentVersion = 1
+
+// migrations[v] upgrades a config file of version v to version v+1.
+var migrations = []func(raw map[string]json.RawMessage) error{
+	// Files without a version predate profiles and options, whose keys they
+	// cannot contain, so the layout is read as is. The credentials they keep
+	// at the top level are moved to the credential store by the token
+	// manager.
+	func(raw map[string]json.RawMessage) error { return nil },
+}
+
 type Data struct {
-	SpDc              string   `json:"sp_dc"`
-	AccessToken       string   `json:"accessToken"`
-	AccessTokenExpire int64    `json:"accessTokenExpire"`
-	AcceptLanguage    []string `json:"accept-language"`
+	Version        int      `json:"version"`
+	AcceptLanguage []string `json:"accept-language"`
+	// DefaultProfile is used when no profile is selected explicitly.
+	DefaultProfile string             `json:"default-profile,omitempty"`
+	Profiles       map[string]Profile `json:"profiles,omitempty"`
+
+	// Credentials written by older versions. They are moved to the
+	// credential store on startup and never written back.
+	LegacySpDc              string `json:"sp_dc,omitempty"`
+	LegacyAccessToken       string `json:"accessToken,omitempty"`
+	LegacyAccessTokenExpire int64  `json:"accessTokenExpire,omitempty"`
+
+	// Options are the defaults of the download command.
+	Options
+
+	// extra holds keys this version does not know, so that writing the
+	// config does not drop settings of newer versions or other tools.
+	extra map[string]json.RawMessage
+}
+
+func (d Data) MarshalJSON() ([]byte, error) {
+	type plain Data
+	return marshalWithExtra(plain(d), d.extra)
+}
+
+func (d *Data) UnmarshalJSON(data []byte) (err error) {
+	type plain Data
+	if err := json.Unmarshal(data, (*plain)(d)); err != nil {
+		return err
+	}
+	d.extra, err = extraKeys(data, reflect.TypeOf(*d))
+	return err
+}
+
+// Profile holds the settings of one account. Empty fields fall back to the
+// top-level settings of the config file.
+type Profile struct {
+	AcceptLanguage []string `json:"accept-language,omitempty"`
+
+	// Options override the top-level options. Credentials is the path of
+	// the credential file holding the cookie and token cache of the profile.
+	Options
+
+	extra map[string]json.RawMessage
+}
+
+func (p Profile) MarshalJSON() ([]byte, error) {
+	type plain Profile
+	return marshalWithExtra(plain(p), p.extra)
+}
+
+func (p *Profile) UnmarshalJSON(data []byte) (err error) {
+	type plain Profile
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	p.extra, err = extraKeys(data, reflect.TypeOf(*p))
+	return err
 }
 
 type Manager struct {
 	configPath string
+	profile    string
 	config     Data
 	defaults   Data
+	logger     *log.Logger
 }
 
 func NewConfigManager() *Manager {
 	log.Debugln("New Config Manager Created")
 	defaults := Data{
-		SpDc:              "",
-		AccessToken:       "",
-		AccessTokenExpire: -1,
-		AcceptLanguage:    []string{},
+		Version:        CurrentVersion,
+		AcceptLanguage: []string{},
 	}
 	return &Manager{
 		configPath: "config.json",
 		config:     defaults,
 		defaults:   defaults,
+		logger:     log.New(nil),
 	}
 }
 
 func (cm *Manager) Initialize() *Manager {
-	log.Debugf("Initializing Config Manager, config path: %s", cm.configPath)
-	if _, err := os.Stat(cm.configPath); errors.Is(err, os.ErrNotExist) {
-		log.Debugf("Config file not found, trying to create one")
-		cm.writeConfig()
+	cm.logger.Debugf("Initializing Config Manager, config path: %s", cm.configPath)
+	if _, err := os.Stat(cm.configPath); !errors.Is(err, os.ErrNotExist) {
+		return cm
+	}
+	cm.logger.Debugf("Config file not found, trying to create one")
+	err := cm.Update(func(conf *Data) error { return nil })
+	if err != nil {
+		cm.logger.Fatalf("Unable to create config file: %v", err)
 	}
 	return cm
 }
 
 func (cm *Manager) SetConfigPath(path string) *Manager {
-	log.Debugf("Set config path to: %s", path)
+	cm.logger.Debugf("Set config path to: %s", path)
 	cm.configPath = path
 	return cm
 }
 
+// SetLogger routes the log output of the manager to l instead of the logger
+// package.
+func (cm *Manager) SetLogger(l *slog.Logger) *Manager {
+	cm.logger = log.New(l)
+	return cm
+}
+
+// SetProfile selects the profile whose settings override the top-level ones.
+func (cm *Manager) SetProfile(name string) *Manager {
+	cm.logger.Debugf("Set config profile to: %s", name)
+	cm.profile = name
+	return cm
+}
+
+// ProfileName returns the selected profile, or the default profile of the
+// config file if none was selected.
+func (cm *Manager) ProfileName() string {
+	if cm.profile != "" {
+		return cm.profile
+	}
+	return cm.config.DefaultProfile
+}
+
+// Profile returns the settings of the selected profile.
+func (cm *Manager) Profile() (Profile, error) {
+	name := cm.ProfileName()
+	if name == "" {
+		return Profile{}, nil
+	}
+	profile, ok := cm.config.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %q not found in config file", name)
+	}
+	return profile, nil
+}
+
+// AcceptLanguage returns the languages of the selected profile, falling back
+// to the top-level setting.
+func (cm *Manager) AcceptLanguage() []string {
+	if profile, err := cm.Profile(); err == nil && len(profile.AcceptLanguage) > 0 {
+		return profile.AcceptLanguage
+	}
+	return cm.config.AcceptLanguage
+}
+
+// DefaultCredentialPath returns the credential file used by a profile when
+// it does not set one.
+func DefaultCredentialPath(profile string) string {
+	if profile == "" {
+		return "credentials.json"
+	}
+	return fmt.Sprintf("credentials.%s.json", profile)
+}
+
 func (cm *Manager) ReadConfig() error {
-	log.Debugf("Reading config file: %s", cm.configPath)
+	conf, err := cm.read()
+	if err != nil {
+		return err
+	}
+	cm.config = conf
+	return nil
+}
+
+// read parses the config file and migrates it to CurrentVersion. The file
+// itself is upgraded the next time the config is written.
+func (cm *Manager) read() (Data, error) {
+	cm.logger.Debugf("Reading config file: %s", cm.configPath)
 	data, err := os.ReadFile(cm.configPath)
 	if err != nil {
-		return fmt.Errorf("unable to read config file: %w", err)
+		return Data{}, fmt.Errorf("unable to read config file: %w", err)
 	}
 
-	if err := json.Unmarshal(data, &cm.config); err != nil {
-		return fmt.Errorf("unable to parse json config file: %w", err)
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Data{}, fmt.Errorf("unable to parse json config file: %w", err)
 	}
-	return nil
+	var version int
+	if v, ok := raw["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return Data{}, fmt.Errorf("invalid config version: %w", err)
+		}
+	}
+	if version > CurrentVersion {
+		return Data{}, fmt.Errorf("config file version %d is newer than the supported version %d", version, CurrentVersion)
+	}
+	if version < CurrentVersion {
+		for v := version; v < CurrentVersion; v++ {
+			if err := migrations[v](raw); err != nil {
+				return Data{}, fmt.Errorf("unable to migrate config file from version %d: %w", v, err)
+			}
+		}
+		raw["version"], _ = json.Marshal(CurrentVersion)
+		if data, err = json.Marshal(raw); err != nil {
+			return Data{}, err
+		}
+		cm.logger.Debugf("Migrated config file from version %d to %d", version, CurrentVersion)
+	}
+
+	conf := cm.defaults
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return Data{}, fmt.Errorf("unable to parse json config file: %w", err)
+	}
+	return conf, nil
 }
 
 func (cm *Manager) Get() Data {
@@ -79,19 +246,54 @@ func (cm *Manager) GetDefault() Data {
 	return cm.defaults
 }
 
-func (cm *Manager) writeConfig() {
-	log.Debugf("Writing config file to: %s", cm.configPath)
-	data, err := json.MarshalIndent(cm.config, "", "  ")
+// Update re-reads the config file while holding a lock on it, applies fn and
+// writes the result back, so that concurrent runs sharing the file do not
+// overwrite each other's changes. A missing file is created from the
+// defaults.
+func (cm *Manager) Update(fn func(conf *Data) error) error {
+	unlock, err := fileutil.Lock(cm.configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	conf, err := cm.read()
+	if errors.Is(err, os.ErrNotExist) {
+		conf, err = cm.defaults, nil
+	}
 	if err != nil {
-		log.Fatalf("Unable to marshal config to json: %v", err)
+		return err
+	}
+	if err := fn(&conf); err != nil {
+		return err
 	}
+	if err := cm.write(conf); err != nil {
+		return err
+	}
+	cm.config = conf
+	return nil
+}
 
-	if err := os.WriteFile(cm.configPath, data, 0644); err != nil {
-		log.Fatalf("Unable to write config to file: %v", err)
+func (cm *Manager) write(conf Data) error {
+	cm.logger.Debugf("Writing config file to: %s", cm.configPath)
+	conf.Version = CurrentVersion
+	data, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal config to json: %w", err)
 	}
+	if err := fileutil.WriteFile(cm.configPath, data, 0644); err != nil {
+		return fmt.Errorf("unable to write config to file: %w", err)
+	}
+	return nil
 }
 
+// Set replaces the config file with newConfig.
 func (cm *Manager) Set(newConfig Data) {
-	cm.config = newConfig
-	cm.writeConfig()
+	err := cm.Update(func(conf *Data) error {
+		*conf = newConfig
+		return nil
+	})
+	if err != nil {
+		cm.logger.Fatalf("Unable to write config: %v", err)
+	}
 }
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Options holds the settings of the download command. Keys are named after
+// the command-line flags, and unset fields leave the flag default in place.
+type Options struct {
+	Quality            string   `json:"quality,omitempty"`
+	Output             string   `json:"output,omitempty"`
+	OutputTemplate     string   `json:"output-template,omitempty"`
+	Debug              *bool    `json:"debug,omitempty"`
+	ConvertToMP3       *bool    `json:"mp3,omitempty"`
+	Convert            string   `json:"convert,omitempty"`
+	NoMetadata         *bool    `json:"no-metadata,omitempty"`
+	ReplayGain         *bool    `json:"replaygain,omitempty"`
+	CoverSize          string   `json:"cover-size,omitempty"`
+	CoverCache         string   `json:"cover-cache,omitempty"`
+	CoverFiles         string   `json:"cover-files,omitempty"`
+	CacheDir           string   `json:"cache-dir,omitempty"`
+	CacheTTL           string   `json:"cache-ttl,omitempty"`
+	FeedBaseURL        string   `json:"feed-base-url,omitempty"`
+	Verify             *bool    `json:"verify,omitempty"`
+	VerifyTolerance    string   `json:"verify-tolerance,omitempty"`
+	Retries            *int     `json:"retries,omitempty"`
+	Archive            string   `json:"archive,omitempty"`
+	Manifest           *bool    `json:"manifest,omitempty"`
+	Dedup              string   `json:"dedup,omitempty"`
+	LibraryIndex       string   `json:"library-index,omitempty"`
+	SyncRemoved        string   `json:"sync-removed,omitempty"`
+	Trash              string   `json:"trash,omitempty"`
+	Watch              []string `json:"watch,omitempty"`
+	Interval           string   `json:"interval,omitempty"`
+	WatchState         string   `json:"watch-state,omitempty"`
+	Listen             string   `json:"listen,omitempty"`
+	Jobs               string   `json:"jobs,omitempty"`
+	Hooks              []string `json:"hook,omitempty"`
+	Notify             []string `json:"notify,omitempty"`
+	NotifyOn           string   `json:"notify-on,omitempty"`
+	NotifyRetries      *int     `json:"notify-retries,omitempty"`
+	NotifyTemplate     string   `json:"notify-template,omitempty"`
+	Credentials        string   `json:"credentials,omitempty"`
+	EncryptCredentials *bool    `json:"encrypt-credentials,omitempty"`
+	SpDcFile           string   `json:"sp-dc-file,omitempty"`
+	NonInteractive     *bool    `json:"non-interactive,omitempty"`
+	TokenRefreshMargin string   `json:"token-refresh-margin,omitempty"`
+	TokenFile          string   `json:"token-file,omitempty"`
+	TokenCommand       string   `json:"token-command,omitempty"`
+	LogFormat          string   `json:"log-format,omitempty"`
+	LogOutput          string   `json:"log-output,omitempty"`
+	LogMaxSize         *int     `json:"log-max-size,omitempty"`
+	LogMaxBackups      *int     `json:"log-max-backups,omitempty"`
+}
+
+// Values returns the options that are set, keyed by flag name, in the
+// string form accepted by flag.FlagSet.Set. List options hold one value per
+// element, to be set in turn on a repeatable flag.
+func (o Options) Values() map[string][]string {
+	values := make(map[string][]string)
+	v := reflect.ValueOf(o)
+	for i := 0; i < v.NumField(); i++ {
+		name := jsonName(v.Type().Field(i))
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			if field.String() != "" {
+				values[name] = []string{field.String()}
+			}
+		case reflect.Pointer:
+			if !field.IsNil() {
+				values[name] = []string{fmt.Sprint(field.Elem().Interface())}
+			}
+		case reflect.Slice:
+			if field.Len() > 0 {
+				values[name] = field.Interface().([]string)
+			}
+		}
+	}
+	return values
+}
+
+// UnknownKeys returns the keys of a config file that no setting reads, such
+// as misspelled options, as dotted paths like "profiles.work.qualty".
+func UnknownKeys(data []byte) ([]string, error) {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("unable to parse json config file: %w", err)
+	}
+
+	unknown := unknownKeys("", root, reflect.TypeOf(Data{}))
+	if raw, ok := root["profiles"]; ok {
+		var profiles map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &profiles); err != nil {
+			return nil, fmt.Errorf("unable to parse profiles: %w", err)
+		}
+		for name, profile := range profiles {
+			unknown = append(unknown, unknownKeys("profiles."+name+".", profile, reflect.TypeOf(Profile{}))...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// extraKeys returns the keys of the JSON object data that are not fields of
+// the struct type t.
+func extraKeys(data []byte, t reflect.Type) (map[string]json.RawMessage, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	addJSONNames(known, t)
+	for key := range object {
+		if known[key] {
+			delete(object, key)
+		}
+	}
+	if len(object) == 0 {
+		return nil, nil
+	}
+	return object, nil
+}
+
+// marshalWithExtra encodes v and adds the keys of extra it does not set.
+func marshalWithExtra(v any, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := object[key]; !ok {
+			object[key] = value
+		}
+	}
+	return json.Marshal(object)
+}
+
+func unknownKeys(prefix string, object map[string]json.RawMessage, t reflect.Type) []string {
+	known := make(map[string]bool)
+	addJSONNames(known, t)
+
+	var unknown []string
+	for key := range object {
+		if !known[key] {
+			unknown = append(unknown, prefix+key)
+		}
+	}
+	return unknown
+}
+
+func addJSONNames(names map[string]bool, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		switch {
+		case field.Anonymous && field.Tag.Get("json") == "":
+			addJSONNames(names, field.Type)
+			continue
+		case !field.IsExported():
+			continue
+		}
+		names[jsonName(field)] = true
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,92 @@
+package credential
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"os"
+)
+
+// Credentials holds the secrets needed to talk to Spotify. They are kept out
+// of the config file, which only stores non-secret settings.
+type Credentials struct {
+	SpDc              string `json:"sp_dc"`
+	AccessToken       string `json:"accessToken"`
+	AccessTokenExpire int64  `json:"accessTokenExpire"`
+}
+
+// Store loads and saves Credentials. Load returns empty Credentials and no
+// error when nothing has been saved yet.
+type Store interface {
+	Load() (Credentials, error)
+	Save(Credentials) error
+}
+
+// Locker is implemented by stores that can be shared between processes.
+// Holding the lock keeps other processes from interleaving their own
+// load-refresh-save sequence with the caller's.
+type Locker interface {
+	Lock() (unlock func() error, err error)
+}
+
+// FileStore keeps credentials as plain JSON in a file only readable by the
+// current user.
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load() (Credentials, error) {
+	var creds Credentials
+	data, err := readSecretFile(s.path)
+	if err != nil || data == nil {
+		return creds, err
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return creds, fmt.Errorf("unable to parse credential file: %w", err)
+	}
+	redact(creds)
+	return creds, nil
+}
+
+func (s *FileStore) Save(creds Credentials) error {
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal credentials: %w", err)
+	}
+	redact(creds)
+	return writeSecretFile(s.path, data)
+}
+
+func (s *FileStore) Lock() (func() error, error) {
+	return fileutil.Lock(s.path)
+}
+
+func readSecretFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credential file: %w", err)
+	}
+	return data, nil
+}
+
+func writeSecretFile(path string, data []byte) error {
+	log.Debugf("Writing credentials to: %s", path)
+	if err := fileutil.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("unable to write credential file: %w", err)
+	}
+	return nil
+}
+
+func redact(creds Credentials) {
+	log.AddSecret(creds.SpDc)
+	log.AddSecret(creds.AccessToken)
+}
@@ -0,0 +1,133 @@
+package credential
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	pbkdf2Iterations = 600000
+	// A credential file with an iteration count out of this range is
+	// rejected: fewer makes guessing the passphrase cheap, more makes
+	// loading it hang.
+	minPBKDF2Iterations = 100000
+	maxPBKDF2Iterations = 10000000
+)
+
+// ErrWrongPassphrase is returned by EncryptedStore.Load when the stored
+// credentials cannot be decrypted with the given passphrase.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted credential file")
+
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedStore keeps credentials encrypted with AES-256-GCM under a key
+// derived from a passphrase with PBKDF2-HMAC-SHA256.
+type EncryptedStore struct {
+	path       string
+	passphrase []byte
+}
+
+func NewEncryptedStore(path string, passphrase string) *EncryptedStore {
+	return &EncryptedStore{path: path, passphrase: []byte(passphrase)}
+}
+
+func (s *EncryptedStore) Lock() (func() error, error) {
+	return fileutil.Lock(s.path)
+}
+
+func (s *EncryptedStore) Load() (Credentials, error) {
+	var creds Credentials
+	data, err := readSecretFile(s.path)
+	if err != nil || data == nil {
+		return creds, err
+	}
+
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return creds, fmt.Errorf("unable to parse credential file: %w", err)
+	}
+	if file.KDF != "pbkdf2-sha256" {
+		return creds, fmt.Errorf("unsupported key derivation function: %s", file.KDF)
+	}
+	if file.Iterations < minPBKDF2Iterations || file.Iterations > maxPBKDF2Iterations {
+		return creds, fmt.Errorf("unsupported iteration count %d, expected %d to %d", file.Iterations, minPBKDF2Iterations, maxPBKDF2Iterations)
+	}
+
+	gcm, err := s.cipher(file.Salt, file.Iterations)
+	if err != nil {
+		return creds, err
+	}
+	if len(file.Nonce) != gcm.NonceSize() {
+		return creds, ErrWrongPassphrase
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return creds, ErrWrongPassphrase
+	}
+
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return creds, fmt.Errorf("unable to parse credentials: %w", err)
+	}
+	redact(creds)
+	return creds, nil
+}
+
+func (s *EncryptedStore) Save(creds Credentials) error {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("unable to marshal credentials: %w", err)
+	}
+	redact(creds)
+
+	file := encryptedFile{
+		Version:    1,
+		KDF:        "pbkdf2-sha256",
+		Iterations: pbkdf2Iterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return fmt.Errorf("unable to generate salt: %w", err)
+	}
+
+	gcm, err := s.cipher(file.Salt, file.Iterations)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return fmt.Errorf("unable to generate nonce: %w", err)
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal credential file: %w", err)
+	}
+	return writeSecretFile(s.path, data)
+}
+
+func (s *EncryptedStore) cipher(salt []byte, iterations int) (cipher.AEAD, error) {
+	if len(s.passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	key := pbkdf2.Key(s.passphrase, salt, iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,110 @@
+package credential
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	creds := Credentials{SpDc: "test-sp-dc", AccessToken: "test-access-token", AccessTokenExpire: 1700000000000}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		// tamper changes the saved file before it is loaded.
+		tamper func(*encryptedFile)
+		// wantErr is the error Load returns, or "" if it succeeds.
+		wantErr string
+	}{
+		{name: "round trip", passphrase: "correct horse"},
+		{name: "passphrase with extra spaces", passphrase: " correct horse ", wantErr: ErrWrongPassphrase.Error()},
+		{name: "wrong passphrase", passphrase: "battery staple", wantErr: ErrWrongPassphrase.Error()},
+		{
+			name:       "tampered ciphertext",
+			passphrase: "correct horse",
+			tamper:     func(f *encryptedFile) { f.Ciphertext[0] ^= 1 },
+			wantErr:    ErrWrongPassphrase.Error(),
+		},
+		{
+			name:       "tampered salt",
+			passphrase: "correct horse",
+			tamper:     func(f *encryptedFile) { f.Salt[0] ^= 1 },
+			wantErr:    ErrWrongPassphrase.Error(),
+		},
+		{
+			name:       "truncated nonce",
+			passphrase: "correct horse",
+			tamper:     func(f *encryptedFile) { f.Nonce = f.Nonce[1:] },
+			wantErr:    ErrWrongPassphrase.Error(),
+		},
+		{
+			name:       "too few iterations",
+			passphrase: "correct horse",
+			tamper:     func(f *encryptedFile) { f.Iterations = 1 },
+			wantErr:    "unsupported iteration count",
+		},
+		{
+			name:       "too many iterations",
+			passphrase: "correct horse",
+			tamper:     func(f *encryptedFile) { f.Iterations = 1 << 30 },
+			wantErr:    "unsupported iteration count",
+		},
+		{
+			name:       "unknown kdf",
+			passphrase: "correct horse",
+			tamper:     func(f *encryptedFile) { f.KDF = "md5" },
+			wantErr:    "unsupported key derivation function",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			if err := NewEncryptedStore(path, "correct horse").Save(creds); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tamperFile(t, path, tt.tamper)
+			}
+
+			got, err := NewEncryptedStore(path, tt.passphrase).Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				if tt.wantErr == ErrWrongPassphrase.Error() && !errors.Is(err, ErrWrongPassphrase) {
+					t.Fatalf("Load() error = %v, want ErrWrongPassphrase", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got != creds {
+				t.Fatalf("Load() = %+v, want %+v", got, creds)
+			}
+		})
+	}
+}
+
+func tamperFile(t *testing.T, path string, tamper func(*encryptedFile)) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	tamper(&file)
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,60 @@
+// Package fileutil writes files shared between concurrent runs, such as the
+// config and credential files.
+package fileutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a temporary file next to name and renames it over
+// name, so that readers see either the old or the new content, never a
+// partial write.
+func WriteFile(name string, data []byte, perm os.FileMode) (err error) {
+	tmpFile, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+		}
+	}()
+
+	if err = tmpFile.Chmod(perm); err != nil {
+		return err
+	}
+	if _, err = tmpFile.Write(data); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), name)
+}
+
+// Lock takes an advisory lock on name, waiting for other processes holding
+// it, and returns the function releasing it. The lock is held on a separate
+// name.lock file, since WriteFile replaces name itself.
+func Lock(name string) (unlock func() error, err error) {
+	lockFile, err := os.OpenFile(name+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file: %w", err)
+	}
+	if err := lockFileHandle(lockFile); err != nil {
+		_ = lockFile.Close()
+		return nil, fmt.Errorf("unable to lock %s: %w", name, err)
+	}
+	return func() error {
+		err := unlockFileHandle(lockFile)
+		if closeErr := lockFile.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
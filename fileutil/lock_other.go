@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package fileutil
+
+import "os"
+
+// Platforms without file locking only get atomic writes.
+func lockFileHandle(f *os.File) error {
+	return nil
+}
+
+func unlockFileHandle(f *os.File) error {
+	return nil
+}
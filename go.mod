@@ -6,6 +6,9 @@ require (
 	github.com/bogem/id3v2 v1.2.0
 	github.com/iyear/gowidevine v0.1.1
 	github.com/u2takey/ffmpeg-go v0.5.0
+	golang.org/x/crypto v0.28.0
+	golang.org/x/term v0.25.0
+	golang.org/x/text v0.19.0
 	google.golang.org/protobuf v1.35.1
 )
 
@@ -16,5 +19,5 @@ require (
 	github.com/chmike/cmac-go v1.1.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/u2takey/go-utils v0.3.1 // indirect
-	golang.org/x/text v0.19.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
 )
@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// NewJSONHandler returns a handler writing one JSON object per record, with
+// registered secrets redacted from the message and string attributes.
+func NewJSONHandler(w io.Writer, opts *HandlerOptions) slog.Handler {
+	var level slog.Leveler = slog.LevelInfo
+	if opts != nil && opts.Level != nil {
+		level = opts.Level
+	}
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	})
+}
+
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	return redactAttrValue(a)
+}
+
+// NewRedactingHandler wraps h so that registered secrets are redacted from
+// the messages and attributes it receives.
+func NewRedactingHandler(h slog.Handler) slog.Handler {
+	if _, ok := h.(*redactingHandler); ok {
+		return h
+	}
+	return &redactingHandler{h: h}
+}
+
+type redactingHandler struct {
+	h slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, redactSecrets(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttrValue(a))
+		return true
+	})
+	return h.h.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttrValue(a)
+	}
+	return &redactingHandler{h: h.h.WithAttrs(redacted)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{h: h.h.WithGroup(name)}
+}
+
+func redactAttrValue(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redacted[i] = redactAttrValue(ga)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindString:
+		a.Value = slog.StringValue(redactSecrets(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(redactSecrets(err.Error()))
+		}
+	}
+	return a
+}
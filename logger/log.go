@@ -3,37 +3,37 @@ package logger
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
 func init() {
-	currentLevel = LevelInfo
-	handler = NewTextHandler(slog.Level(currentLevel))
-	logger = slog.New(handler)
+	level.Set(slog.Level(LevelInfo))
+	logger = slog.New(NewTextHandler(os.Stdout, &HandlerOptions{
+		Level: level,
+		Color: colorEnabled(os.Stdout),
+	}))
 }
 
-var handler *TextHandler
 var logger *slog.Logger
-var currentLevel Level
-
-type Level slog.Level
+var level = new(slog.LevelVar)
 
-type TextHandler struct {
-	level slog.Level
+var secrets struct {
+	sync.RWMutex
+	values []string
 }
 
-const (
-	Reset   = "\033[0m"
-	Red     = "\033[31m"
-	Yellow  = "\033[33m"
-	Blue    = "\033[34m"
-	Green   = "\033[32m"
-	Magenta = "\033[35m"
-)
+type Level slog.Level
 
 const (
 	LevelDebug  Level = -4
@@ -43,124 +43,267 @@ const (
 	LevelSilent Level = 16
 )
 
-func levelColor(level slog.Level) string {
-	switch level {
-	case slog.LevelDebug:
-		return Blue
-	case slog.LevelInfo:
-		return Green
-	case slog.LevelWarn:
-		return Yellow
-	case slog.LevelError:
-		return Red
+// HandlerOptions configures the handlers of this package.
+type HandlerOptions struct {
+	// Level is the minimum level of records that are written.
+	Level slog.Leveler
+	// Color wraps text lines in ANSI colour codes by level.
+	Color bool
+}
+
+// Configure replaces the handler of the package logger, writing to w in the
+// given format. Text output is coloured only if w is a terminal and
+// $NO_COLOR is not set.
+func Configure(format string, w io.Writer) error {
+	opts := &HandlerOptions{Level: level, Color: colorEnabled(w)}
+	switch format {
+	case FormatText, "":
+		SetHandler(NewTextHandler(w, opts))
+	case FormatJSON:
+		SetHandler(NewJSONHandler(w, opts))
 	default:
-		return Reset
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatText, FormatJSON)
 	}
+	return nil
 }
 
-func NewTextHandler(level slog.Level) *TextHandler {
-	return &TextHandler{level: level}
+// SetHandler replaces the handler of the package logger.
+func SetHandler(h slog.Handler) {
+	logger = slog.New(h)
 }
 
-func (h *TextHandler) Handle(_ context.Context, r slog.Record) error {
-	_, file, line, ok := runtime.Caller(4)
-	if ok {
-		file = filepath.Base(file)
-	} else {
-		file = "unknown"
-		line = 0
+// colorEnabled reports whether w is a terminal and colour is not disabled
+// with $NO_COLOR (https://no-color.org).
+func colorEnabled(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
 	}
-
-	timestamp := time.Now().Format("2006/01/02 15:04:05")
-
-	color := levelColor(r.Level)
-	logLine := fmt.Sprintf("%s%s [%s] %s (%s:%d)%s\n",
-		color, timestamp, r.Level.String(), r.Message, file, line, Reset)
-
-	fmt.Print(logLine)
-	return nil
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
-func (h *TextHandler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= h.level
+// AddSecret registers a value, such as a cookie or token, that is replaced
+// with [REDACTED] wherever it appears in log output.
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, s := range secrets.values {
+		if s == secret {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, secret)
 }
 
-func (h *TextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	return &TextHandler{level: h.level}
+func redactSecrets(msg string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, s := range secrets.values {
+		msg = strings.ReplaceAll(msg, s, "[REDACTED]")
+	}
+	return msg
 }
 
-func (h *TextHandler) WithGroup(name string) slog.Handler {
-	return h
+func SetLevel(l Level) {
+	level.Set(slog.Level(l))
 }
 
-func SetLevel(level Level) {
-	currentLevel = level
-	handler.level = slog.Level(currentLevel)
+func GetLevel() Level {
+	return Level(level.Level())
 }
 
-func GetLevel() Level {
-	return currentLevel
+// logAt writes msg with the caller of the exported function calling it as
+// the source.
+func logAt(l *slog.Logger, lvl slog.Level, msg string) {
+	ctx := context.Background()
+	if !l.Enabled(ctx, lvl) {
+		return
+	}
+	var pcs [1]uintptr
+	// skip runtime.Callers, logAt and the exported function
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), lvl, msg, pcs[0])
+	_ = l.Handler().Handle(ctx, r)
 }
 
 func Info(msg string) {
-	logger.Info(msg)
+	logAt(logger, slog.LevelInfo, msg)
 }
 
 func Infoln(args ...any) {
-	logger.Info(fmt.Sprint(args...))
+	logAt(logger, slog.LevelInfo, fmt.Sprint(args...))
 }
 
 func Infof(format string, args ...any) {
-	logger.Info(fmt.Sprintf(format, args...))
+	logAt(logger, slog.LevelInfo, fmt.Sprintf(format, args...))
 }
 
 func Debug(msg string) {
-	logger.Debug(msg)
+	logAt(logger, slog.LevelDebug, msg)
 }
 
 func Debugln(args ...any) {
-	logger.Debug(fmt.Sprint(args...))
+	logAt(logger, slog.LevelDebug, fmt.Sprint(args...))
 }
 
 func Debugf(format string, args ...any) {
-	logger.Debug(fmt.Sprintf(format, args...))
+	logAt(logger, slog.LevelDebug, fmt.Sprintf(format, args...))
 }
 
 func Warn(msg string) {
-	logger.Warn(msg)
+	logAt(logger, slog.LevelWarn, msg)
 }
 
 func Warnln(args ...any) {
-	logger.Warn(fmt.Sprint(args...))
+	logAt(logger, slog.LevelWarn, fmt.Sprint(args...))
 }
 
 func Warnf(format string, args ...any) {
-	logger.Warn(fmt.Sprintf(format, args...))
+	logAt(logger, slog.LevelWarn, fmt.Sprintf(format, args...))
 }
 
 func Error(msg string) {
-	logger.Error(msg)
+	logAt(logger, slog.LevelError, msg)
 }
 
 func Errorln(args ...any) {
-	logger.Error(fmt.Sprint(args...))
+	logAt(logger, slog.LevelError, fmt.Sprint(args...))
 }
 
 func Errorf(format string, args ...any) {
-	logger.Error(fmt.Sprintf(format, args...))
+	logAt(logger, slog.LevelError, fmt.Sprintf(format, args...))
 }
 
 func Fatal(msg string) {
-	logger.Error(msg)
+	logAt(logger, slog.LevelError, msg)
 	os.Exit(1)
 }
 
 func Fatalln(args ...any) {
-	logger.Error(fmt.Sprint(args...))
+	logAt(logger, slog.LevelError, fmt.Sprint(args...))
 	os.Exit(1)
 }
 
 func Fatalf(format string, args ...any) {
-	logger.Error(fmt.Sprintf(format, args...))
+	logAt(logger, slog.LevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// Default returns the logger behind the package-level helpers, for use as a
+// slog.Logger.
+func Default() *slog.Logger {
+	return logger
+}
+
+// Logger adds context fields, such as the ID of the track being downloaded,
+// to every message it writes. The zero value writes to the package logger.
+type Logger struct {
+	l *slog.Logger
+}
+
+// New returns a Logger writing to l, with registered secrets redacted. A nil
+// l writes to the package logger, following later calls to Configure and
+// SetHandler.
+func New(l *slog.Logger) *Logger {
+	if l == nil {
+		return &Logger{}
+	}
+	return &Logger{l: slog.New(NewRedactingHandler(l.Handler()))}
+}
+
+// With returns a Logger adding the given key-value pairs to its messages.
+func With(args ...any) *Logger {
+	return &Logger{l: logger.With(args...)}
+}
+
+// With returns a Logger adding the given key-value pairs to the fields of l.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{l: l.get().With(args...)}
+}
+
+// Slog returns l as a slog.Logger.
+func (l *Logger) Slog() *slog.Logger {
+	return l.get()
+}
+
+// Enabled reports whether messages of the given level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return l.get().Enabled(context.Background(), slog.Level(level))
+}
+
+func (l *Logger) get() *slog.Logger {
+	if l == nil || l.l == nil {
+		return logger
+	}
+	return l.l
+}
+
+func (l *Logger) Info(msg string) {
+	logAt(l.get(), slog.LevelInfo, msg)
+}
+
+func (l *Logger) Infoln(args ...any) {
+	logAt(l.get(), slog.LevelInfo, fmt.Sprint(args...))
+}
+
+func (l *Logger) Infof(format string, args ...any) {
+	logAt(l.get(), slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Debug(msg string) {
+	logAt(l.get(), slog.LevelDebug, msg)
+}
+
+func (l *Logger) Debugln(args ...any) {
+	logAt(l.get(), slog.LevelDebug, fmt.Sprint(args...))
+}
+
+func (l *Logger) Debugf(format string, args ...any) {
+	logAt(l.get(), slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warn(msg string) {
+	logAt(l.get(), slog.LevelWarn, msg)
+}
+
+func (l *Logger) Warnln(args ...any) {
+	logAt(l.get(), slog.LevelWarn, fmt.Sprint(args...))
+}
+
+func (l *Logger) Warnf(format string, args ...any) {
+	logAt(l.get(), slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Error(msg string) {
+	logAt(l.get(), slog.LevelError, msg)
+}
+
+func (l *Logger) Errorln(args ...any) {
+	logAt(l.get(), slog.LevelError, fmt.Sprint(args...))
+}
+
+func (l *Logger) Errorf(format string, args ...any) {
+	logAt(l.get(), slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Fatal(msg string) {
+	logAt(l.get(), slog.LevelError, msg)
+	os.Exit(1)
+}
+
+func (l *Logger) Fatalln(args ...any) {
+	logAt(l.get(), slog.LevelError, fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (l *Logger) Fatalf(format string, args ...any) {
+	logAt(l.get(), slog.LevelError, fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file that is renamed to name.1 once it grows past a
+// size limit, shifting older files up to name.<backups> and dropping the
+// oldest.
+type RotatingFile struct {
+	mu      sync.Mutex
+	name    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+// NewRotatingFile opens name for appending. A maxSize of zero or less
+// disables rotation.
+func NewRotatingFile(name string, maxSize int64, backups int) (*RotatingFile, error) {
+	f := &RotatingFile{name: name, maxSize: maxSize, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to open log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.backups > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d", f.name, f.backups))
+		for i := f.backups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", f.name, i), fmt.Sprintf("%s.%d", f.name, i+1))
+		}
+		if err := os.Rename(f.name, f.name+".1"); err != nil {
+			return fmt.Errorf("unable to rotate log file: %w", err)
+		}
+	} else if err := os.Remove(f.name); err != nil {
+		return fmt.Errorf("unable to rotate log file: %w", err)
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,160 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	Reset   = "\033[0m"
+	Red     = "\033[31m"
+	Yellow  = "\033[33m"
+	Blue    = "\033[34m"
+	Green   = "\033[32m"
+	Magenta = "\033[35m"
+)
+
+func levelColor(level slog.Level) string {
+	switch level {
+	case slog.LevelDebug:
+		return Blue
+	case slog.LevelInfo:
+		return Green
+	case slog.LevelWarn:
+		return Yellow
+	case slog.LevelError:
+		return Red
+	default:
+		return Reset
+	}
+}
+
+// TextHandler writes one line per record: the time, level and message,
+// then the attributes as key=value pairs and the source location.
+type TextHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	opts   HandlerOptions
+	prefix string
+	attrs  string
+}
+
+func NewTextHandler(w io.Writer, opts *HandlerOptions) *TextHandler {
+	h := &TextHandler{mu: &sync.Mutex{}, w: w}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	return h
+}
+
+func (h *TextHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if h.opts.Color {
+		b.WriteString(levelColor(r.Level))
+	}
+	b.WriteString(r.Time.Format("2006/01/02 15:04:05"))
+	b.WriteString(" [")
+	b.WriteString(r.Level.String())
+	b.WriteString("] ")
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.prefix, a)
+		return true
+	})
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		b.WriteString(" (")
+		b.WriteString(filepath.Base(frame.File))
+		b.WriteString(":")
+		b.WriteString(strconv.Itoa(frame.Line))
+		b.WriteString(")")
+	}
+	if h.opts.Color {
+		b.WriteString(Reset)
+	}
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, redactSecrets(b.String()))
+	return err
+}
+
+func (h *TextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+func (h *TextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		appendAttr(&b, h.prefix, a)
+	}
+	h2 := *h
+	h2.attrs += b.String()
+	return &h2
+}
+
+func (h *TextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+
+	var value string
+	switch a.Value.Kind() {
+	case slog.KindTime:
+		value = a.Value.Time().Format(time.RFC3339)
+	default:
+		value = a.Value.String()
+	}
+	if needsQuoting(value) {
+		value = strconv.Quote(value)
+	}
+	b.WriteString(" ")
+	b.WriteString(prefix)
+	b.WriteString(a.Key)
+	b.WriteString("=")
+	b.WriteString(value)
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+// Package notify sends notifications about downloads to webhooks and by
+// email.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"log/slog"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// DefaultRetries is how many more times a notification is sent to a
+	// sink that failed.
+	DefaultRetries = 3
+	// DefaultRetryDelay is the wait before the first retry. It doubles with
+	// every retry.
+	DefaultRetryDelay = 2 * time.Second
+	// DefaultSendTimeout bounds each attempt to send a notification.
+	DefaultSendTimeout = 30 * time.Second
+)
+
+// queueSize is how many notifications of failed items may wait to be sent.
+// More are dropped, since the notification of the batch lists them too.
+const queueSize = 100
+
+// DefaultTemplate defines the subject and body of notifications. A template
+// passed to SetTemplate may redefine either.
+const DefaultTemplate = `{{define "subject"}}sp-dl-go: {{if eq .Stage "item-failed"}}{{.Type}} {{.ID}} failed{{else}}{{.Type}} {{.ID}} finished{{if .Failed}} with {{.Failed}} failure(s){{end}}{{end}}{{end}}
+{{- define "body"}}{{if eq .Stage "item-failed"}}Downloading {{.Type}} {{.ID}} failed: {{.Error}}
+{{else}}Finished {{.Type}} {{.ID}}: {{.Succeeded}} of {{.Total}} item(s) downloaded, {{.Failed}} failed.
+{{range .FailedItems}}
+- {{.ID}}: {{.Error}}{{end}}
+{{end}}{{end}}`
+
+// Event is what a notification is about: a failed item, or a finished batch
+// of items. It is the data of the templates.
+type Event struct {
+	// Stage is spotify.HookItemFailed or spotify.HookAfterBatch.
+	Stage string         `json:"stage"`
+	Type  spotify.IDType `json:"type"`
+	ID    string         `json:"id"`
+	// Error is why the item failed.
+	Error string `json:"error,omitempty"`
+	// Total, Succeeded and Failed count the items of a batch.
+	Total       int                `json:"total"`
+	Succeeded   int                `json:"succeeded"`
+	Failed      int                `json:"failed"`
+	FailedIDs   []string           `json:"failed_ids,omitempty"`
+	FailedItems []spotify.HookItem `json:"-"`
+	Time        time.Time          `json:"time"`
+}
+
+// NewEvent returns the Event of a hook payload.
+func NewEvent(payload spotify.HookPayload) Event {
+	e := Event{Stage: payload.Stage, Type: payload.Type, ID: payload.ID, Error: payload.Error, Time: time.Now().UTC()}
+	if payload.Stage == spotify.HookItemFailed {
+		e.Total, e.Failed, e.FailedIDs = 1, 1, []string{payload.ID}
+		e.FailedItems = []spotify.HookItem{{ID: payload.ID, Error: payload.Error}}
+		return e
+	}
+	e.Total = len(payload.Items)
+	for _, item := range payload.Items {
+		if item.Error != "" {
+			e.FailedIDs = append(e.FailedIDs, item.ID)
+			e.FailedItems = append(e.FailedItems, item)
+		}
+	}
+	e.Failed = len(e.FailedIDs)
+	e.Succeeded = e.Total - e.Failed
+	return e
+}
+
+// Message is a rendered notification.
+type Message struct {
+	Event
+	Subject string `json:"subject"`
+	Body    string `json:"message"`
+}
+
+// Sink delivers notifications.
+type Sink interface {
+	Send(ctx context.Context, msg Message) error
+	// String describes the sink for logs, without credentials.
+	String() string
+}
+
+// permanentError is an error that sending again will not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// ParseSink parses a sink given as a URL: http:// or https:// for a
+// Webhook, smtp:// or smtps:// for an SMTP sink, see ParseSMTP.
+func ParseSink(spec string) (Sink, error) {
+	scheme, _, _ := strings.Cut(spec, "://")
+	switch strings.ToLower(scheme) {
+	case "http", "https":
+		return NewWebhook(spec)
+	case "smtp", "smtps":
+		return ParseSMTP(spec)
+	}
+	return nil, fmt.Errorf("invalid notification sink %q, expected an http(s):// or smtp(s):// URL", spec)
+}
+
+// Notifier renders events with its template and sends them to its sinks.
+type Notifier struct {
+	sinks      []Sink
+	stages     map[string]bool
+	retries    int
+	retryDelay time.Duration
+	template   *template.Template
+	logger     *log.Logger
+
+	queueOnce sync.Once
+	queue     chan Event
+	mu        sync.Mutex
+	idle      *sync.Cond
+	pending   int
+}
+
+// New returns a Notifier without sinks, which notifies of failed items and
+// finished batches with DefaultTemplate.
+func New() *Notifier {
+	n := &Notifier{
+		stages:     map[string]bool{spotify.HookItemFailed: true, spotify.HookAfterBatch: true},
+		retries:    DefaultRetries,
+		retryDelay: DefaultRetryDelay,
+		template:   template.Must(template.New("notify").Parse(DefaultTemplate)),
+		logger:     log.New(nil),
+	}
+	n.idle = sync.NewCond(&n.mu)
+	return n
+}
+
+// AddSink adds a sink every notification is sent to.
+func (n *Notifier) AddSink(s Sink) *Notifier {
+	n.sinks = append(n.sinks, s)
+	return n
+}
+
+// SetStages sets the events to notify of: spotify.HookItemFailed,
+// spotify.HookAfterBatch or both.
+func (n *Notifier) SetStages(stages ...string) error {
+	selected := make(map[string]bool)
+	for _, stage := range stages {
+		switch stage {
+		case spotify.HookItemFailed, spotify.HookAfterBatch:
+			selected[stage] = true
+		default:
+			return fmt.Errorf("invalid notification stage %q, expected %s or %s", stage, spotify.HookItemFailed, spotify.HookAfterBatch)
+		}
+	}
+	n.stages = selected
+	return nil
+}
+
+// SetRetries sets how many more times a notification is sent to a failing
+// sink, waiting delay before the first retry and twice as long before each
+// next one.
+func (n *Notifier) SetRetries(retries int, delay time.Duration) error {
+	if retries < 0 {
+		return errors.New("notification retries must not be negative")
+	}
+	if delay < 0 {
+		return errors.New("notification retry delay must not be negative")
+	}
+	n.retries, n.retryDelay = retries, delay
+	return nil
+}
+
+// SetTemplate parses text, a text/template redefining the "subject" or
+// "body" templates, or both. Templates get an Event.
+func (n *Notifier) SetTemplate(text string) error {
+	t := template.Must(template.New("notify").Parse(DefaultTemplate))
+	if _, err := t.Parse(text); err != nil {
+		return fmt.Errorf("invalid notification template: %v", err)
+	}
+	// Render a sample, so that errors such as unknown fields show up now
+	// rather than at the first notification
+	if _, err := render(t, Event{Stage: spotify.HookAfterBatch, Type: spotify.ALBUM}); err != nil {
+		return fmt.Errorf("invalid notification template: %v", err)
+	}
+	n.template = t
+	return nil
+}
+
+// SetLogger routes the log output of the Notifier to l instead of the logger
+// package.
+func (n *Notifier) SetLogger(l *slog.Logger) *Notifier {
+	n.logger = log.New(l)
+	return n
+}
+
+// Attach adds hooks to sp sending the notifications of its downloads.
+// Notifications of failed items are queued and sent in the background, so
+// that a slow sink does not hold up downloading. The queue is flushed once a
+// batch is done, before its own notification is sent.
+//
+// The hooks do not use the timeout of hooks, but one fitting the sinks and
+// retries at the time of each notification.
+func (n *Notifier) Attach(sp *spotify.Downloader) error {
+	if n.stages[spotify.HookItemFailed] {
+		err := sp.AddHook(spotify.Hook{
+			Stage: spotify.HookItemFailed,
+			Func: func(_ context.Context, payload spotify.HookPayload) error {
+				n.enqueue(NewEvent(payload))
+				return nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return sp.AddHook(spotify.Hook{
+		Stage: spotify.HookAfterBatch,
+		Func: func(_ context.Context, payload spotify.HookPayload) error {
+			if !n.Flush(n.timeout()) {
+				n.logger.Warnln("Notifications of failed items are still being sent")
+			}
+			if !n.stages[spotify.HookAfterBatch] {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), n.timeout())
+			defer cancel()
+			return n.Notify(ctx, NewEvent(payload))
+		},
+	})
+}
+
+// enqueue queues e to be sent in the background.
+func (n *Notifier) enqueue(e Event) {
+	n.queueOnce.Do(func() {
+		n.queue = make(chan Event, queueSize)
+		go n.sendQueued()
+	})
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case n.queue <- e:
+		n.pending++
+	default:
+		n.logger.Warnf("Notification queue is full, dropped the notification of %s [%s]", e.Type, e.ID)
+	}
+}
+
+func (n *Notifier) sendQueued() {
+	for e := range n.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), n.timeout())
+		if err := n.Notify(ctx, e); err != nil {
+			n.logger.Warnf("%v", err)
+		}
+		cancel()
+
+		n.mu.Lock()
+		n.pending--
+		n.idle.Broadcast()
+		n.mu.Unlock()
+	}
+}
+
+// Flush waits up to timeout for the queued notifications to be sent, and
+// reports whether they were.
+func (n *Notifier) Flush(timeout time.Duration) bool {
+	timer := time.AfterFunc(timeout, func() {
+		n.mu.Lock()
+		n.idle.Broadcast()
+		n.mu.Unlock()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for n.pending > 0 && time.Now().Before(deadline) {
+		n.idle.Wait()
+	}
+	return n.pending == 0
+}
+
+// timeout is how long Notify may take with the current sinks, every retry
+// included. Sinks are sent to one after another.
+func (n *Notifier) timeout() time.Duration {
+	total := DefaultSendTimeout
+	delay := n.retryDelay
+	for i := 0; i < n.retries; i++ {
+		total += delay + DefaultSendTimeout
+		delay *= 2
+	}
+	return total * time.Duration(max(len(n.sinks), 1))
+}
+
+// Notify renders e and sends it to every sink, retrying the sinks that
+// fail. It returns the errors of the sinks that still failed.
+func (n *Notifier) Notify(ctx context.Context, e Event) error {
+	msg, err := render(n.template, e)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, sink := range n.sinks {
+		if err := n.send(ctx, sink, msg); err != nil {
+			errs = append(errs, fmt.Errorf("failed to notify %s: %w", sink, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) send(ctx context.Context, sink Sink, msg Message) error {
+	delay := n.retryDelay
+	for attempt := 0; ; attempt++ {
+		sendCtx, cancel := context.WithTimeout(ctx, DefaultSendTimeout)
+		err := sink.Send(sendCtx, msg)
+		cancel()
+		var permanent *permanentError
+		if err == nil || attempt == n.retries || errors.As(err, &permanent) {
+			return err
+		}
+		n.logger.Warnf("Failed to notify %s, retrying in %s: %v", sink, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func render(t *template.Template, e Event) (Message, error) {
+	msg := Message{Event: e}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "subject", e); err != nil {
+		return msg, err
+	}
+	// Header lines must not break
+	msg.Subject = strings.Join(strings.Fields(buf.String()), " ")
+	buf.Reset()
+	if err := t.ExecuteTemplate(&buf, "body", e); err != nil {
+		return msg, err
+	}
+	msg.Body = buf.String()
+	return msg, nil
+}
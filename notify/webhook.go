@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Webhook posts notifications as JSON: the Event with the rendered
+// "subject" and "message".
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhook returns a Webhook posting to rawURL.
+func NewWebhook(rawURL string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	return &Webhook{URL: rawURL, Client: http.DefaultClient}, nil
+}
+
+// Send posts msg. Responses other than 2xx fail, and are retried unless they
+// are client errors other than 408 and 429.
+func (w *Webhook) Send(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return &permanentError{fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+	return fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// String returns the scheme and host of the webhook, leaving out the path
+// and query, which often hold a token.
+func (w *Webhook) String() string {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return "webhook"
+	}
+	return "webhook " + u.Scheme + "://" + u.Host
+}
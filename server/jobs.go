@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Job states.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusDone      = "done"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// jobsVersion is the format version of job files.
+const jobsVersion = 1
+
+// Job is a download submitted to the server.
+type Job struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Options override settings of the server's Downloader for this job,
+	// keyed by flag name, see jobOptions.
+	Options  map[string]string  `json:"options,omitempty"`
+	Status   string             `json:"status"`
+	Error    string             `json:"error,omitempty"`
+	Items    []spotify.HookItem `json:"items,omitempty"`
+	Created  time.Time          `json:"created"`
+	Started  *time.Time         `json:"started,omitempty"`
+	Finished *time.Time         `json:"finished,omitempty"`
+}
+
+func (j *Job) finished() bool {
+	switch j.Status {
+	case StatusDone, StatusFailed, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// jobOptions are the settings a job may override. The output folder is left
+// out on purpose, so that clients cannot write outside it.
+var jobOptions = map[string]func(sp *spotify.Downloader, value string) error{
+	"quality":         func(sp *spotify.Downloader, value string) error { return sp.SetQuality(value) },
+	"output-template": func(sp *spotify.Downloader, value string) error { return sp.SetOutputTemplate(value) },
+	"filename-policy": func(sp *spotify.Downloader, value string) error { return sp.SetFilenamePolicy(value) },
+	"convert":         func(sp *spotify.Downloader, value string) error { return sp.SetConvert(value) },
+	"dedup":           func(sp *spotify.Downloader, value string) error { return sp.SetDedupPolicy(value) },
+	"no-metadata": func(sp *spotify.Downloader, value string) error {
+		b, err := strconv.ParseBool(value)
+		sp.SkipAddingMetadata(b)
+		return err
+	},
+	"replaygain": func(sp *spotify.Downloader, value string) error {
+		b, err := strconv.ParseBool(value)
+		sp.ReplayGain(b)
+		return err
+	},
+}
+
+// applyOptions applies the options of a job to sp.
+func applyOptions(sp *spotify.Downloader, options map[string]string) error {
+	var errs []error
+	for name, value := range options {
+		set, ok := jobOptions[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown option %q", name))
+			continue
+		}
+		if err := set(sp, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q for %s: %v", value, name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type jobsFile struct {
+	Version int    `json:"version"`
+	Jobs    []*Job `json:"jobs"`
+}
+
+// readJobs reads the jobs saved at path. Jobs that were running when the
+// server stopped are queued again.
+func readJobs(path string) ([]*Job, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file jobsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse jobs file [%s]: %w", path, err)
+	}
+	if file.Version > jobsVersion {
+		return nil, fmt.Errorf("jobs file [%s] has version %d, newer than the supported version %d", path, file.Version, jobsVersion)
+	}
+	for _, job := range file.Jobs {
+		if job.Status == StatusRunning {
+			job.Status = StatusQueued
+			job.Started = nil
+			job.Items = nil
+		}
+	}
+	sort.Slice(file.Jobs, func(i, j int) bool {
+		return file.Jobs[i].Created.Before(file.Jobs[j].Created)
+	})
+	return file.Jobs, nil
+}
+
+func writeJobs(path string, jobs []*Job) error {
+	data, err := json.MarshalIndent(jobsFile{Version: jobsVersion, Jobs: jobs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(path, data, 0644)
+}
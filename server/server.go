@@ -0,0 +1,538 @@
+// Package server runs download jobs submitted over a REST API.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/spotify"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRequestBody bounds the size of a submitted job.
+const maxRequestBody = 1 << 20
+
+// keepAliveInterval is how often an idle event stream gets a comment, so
+// that proxies do not close it.
+const keepAliveInterval = 15 * time.Second
+
+// Server runs jobs one at a time, in the order they were submitted, each on
+// a clone of the Downloader it was created with. Jobs are saved to a file,
+// so that queued jobs, and the job running when the server stopped, run
+// after a restart.
+type Server struct {
+	sp       *spotify.Downloader
+	jobsPath string
+	token    string
+	logger   *log.Logger
+
+	mu          sync.Mutex
+	jobs        []*Job
+	byID        map[string]*Job
+	cancels     map[string]context.CancelFunc
+	subscribers map[string]map[chan []byte]bool
+	wake        chan struct{}
+}
+
+// New returns a Server running jobs on clones of sp, an initialised
+// Downloader, and saving them to jobsPath.
+func New(sp *spotify.Downloader, jobsPath string) (*Server, error) {
+	jobs, err := readJobs(jobsPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		sp:          sp,
+		jobsPath:    jobsPath,
+		logger:      log.New(nil),
+		jobs:        jobs,
+		byID:        make(map[string]*Job),
+		cancels:     make(map[string]context.CancelFunc),
+		subscribers: make(map[string]map[chan []byte]bool),
+		wake:        make(chan struct{}, 1),
+	}
+	for _, job := range jobs {
+		s.byID[job.ID] = job
+	}
+	return s, nil
+}
+
+// SetToken requires requests to carry "Authorization: Bearer <token>". The
+// API is open if token is empty.
+func (s *Server) SetToken(token string) *Server {
+	s.token = token
+	if token != "" {
+		log.AddSecret(token)
+	}
+	return s
+}
+
+// SetLogger routes the log output of the Server to l instead of the logger
+// package.
+func (s *Server) SetLogger(l *slog.Logger) *Server {
+	s.logger = log.New(l)
+	return s
+}
+
+// Run runs queued jobs until ctx is done. The running job is stopped before
+// its next item and queued again.
+func (s *Server) Run(ctx context.Context) {
+	for {
+		if job := s.nextJob(); job != nil {
+			s.runJob(ctx, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Server) nextJob() *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.Status == StatusQueued {
+			return job
+		}
+	}
+	return nil
+}
+
+func (s *Server) runJob(ctx context.Context, job *Job) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	if ctx.Err() != nil || job.Status != StatusQueued {
+		s.mu.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	job.Status, job.Started = StatusRunning, &now
+	s.cancels[job.ID] = cancel
+	s.changed(job)
+	s.mu.Unlock()
+
+	l := s.logger.With("job", job.ID)
+	l.Infof("Running job [%s]: %s", job.ID, job.URL)
+
+	sp := s.sp.Clone()
+	err := applyOptions(sp, job.Options)
+	if err == nil {
+		err = errors.Join(
+			sp.AddHook(spotify.Hook{Stage: spotify.HookAfterTagging, Func: func(_ context.Context, payload spotify.HookPayload) error {
+				s.itemDone(job, spotify.HookItem{ID: payload.ID, Path: payload.Path})
+				return nil
+			}}),
+			sp.AddHook(spotify.Hook{Stage: spotify.HookItemFailed, Func: func(_ context.Context, payload spotify.HookPayload) error {
+				s.itemDone(job, spotify.HookItem{ID: payload.ID, Error: payload.Error})
+				return nil
+			}}),
+			sp.AddHook(spotify.Hook{Stage: spotify.HookAfterBatch, Func: func(_ context.Context, payload spotify.HookPayload) error {
+				s.mu.Lock()
+				job.Items = payload.Items
+				s.mu.Unlock()
+				return nil
+			}}),
+		)
+	}
+	if err == nil {
+		err = sp.DownloadContext(jobCtx, job.URL)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cancels, job.ID)
+	failed := 0
+	for _, item := range job.Items {
+		if item.Error != "" {
+			failed++
+		}
+	}
+	now = time.Now().UTC()
+	switch {
+	case ctx.Err() != nil:
+		// The server is stopping, run the job again after a restart
+		job.Status, job.Started, job.Items = StatusQueued, nil, nil
+	case jobCtx.Err() != nil:
+		job.Status, job.Finished = StatusCancelled, &now
+	case err != nil:
+		job.Status, job.Error, job.Finished = StatusFailed, err.Error(), &now
+	case failed > 0:
+		job.Status, job.Error, job.Finished = StatusFailed, fmt.Sprintf("%d of %d item(s) failed", failed, len(job.Items)), &now
+	default:
+		job.Status, job.Finished = StatusDone, &now
+	}
+	s.changed(job)
+	l.Infof("Job [%s] %s", job.ID, job.Status)
+}
+
+// itemDone records an item of a running job, downloaded or failed, and
+// tells its subscribers.
+func (s *Server) itemDone(job *Job, item spotify.HookItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := findItem(job.Items, item.ID); i >= 0 {
+		job.Items[i] = item
+	} else {
+		job.Items = append(job.Items, item)
+	}
+	s.publish(job.ID, "item", item)
+}
+
+func findItem(items []spotify.HookItem, id string) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// changed saves the jobs and tells the subscribers of job its status. It
+// ends their streams once the job is finished. s.mu must be held.
+func (s *Server) changed(job *Job) {
+	if err := writeJobs(s.jobsPath, s.jobs); err != nil {
+		s.logger.Errorf("Failed to save jobs: %v", err)
+	}
+	s.publish(job.ID, "status", job)
+	if job.finished() {
+		for ch := range s.subscribers[job.ID] {
+			close(ch)
+		}
+		delete(s.subscribers, job.ID)
+	}
+}
+
+// publish sends an event to the subscribers of a job, dropping it for
+// subscribers too slow to keep up. s.mu must be held.
+func (s *Server) publish(jobID string, name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	event := []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", name, data))
+	for ch := range s.subscribers[jobID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// ServeHTTP serves the API:
+//
+//	POST /jobs                    submit {"url": ..., "options": {...}}
+//	GET  /jobs                    list jobs
+//	GET  /jobs/{id}               get a job
+//	POST /jobs/{id}/cancel        cancel a queued or running job
+//	GET  /jobs/{id}/events        stream status and item events
+//	GET  /jobs/{id}/files         list the downloaded files
+//	GET  /jobs/{id}/files/{item}  fetch the downloaded file of an item
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "jobs" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.handleList(w)
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.handleSubmit(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.handleGet(w, parts[1])
+	case len(parts) == 3 && parts[2] == "cancel" && r.Method == http.MethodPost:
+		s.handleCancel(w, parts[1])
+	case len(parts) == 3 && parts[2] == "events" && r.Method == http.MethodGet:
+		s.handleEvents(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "files" && r.Method == http.MethodGet:
+		s.handleFiles(w, parts[1])
+	case len(parts) == 4 && parts[2] == "files" && r.Method == http.MethodGet:
+		s.handleFile(w, r, parts[1], parts[3])
+	case len(parts) <= 4:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL     string            `json:"url"`
+		Options map[string]string `json:"options"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid job: %v", err))
+		return
+	}
+	if _, idType, err := spotify.GetIDType(req.URL); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if !validType(idType) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported type %q", idType))
+		return
+	}
+	if err := applyOptions(s.sp.Clone(), req.Options); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	job := &Job{ID: id, URL: req.URL, Options: req.Options, Status: StatusQueued, Created: time.Now().UTC()}
+
+	s.mu.Lock()
+	s.jobs = append(s.jobs, job)
+	s.byID[id] = job
+	s.changed(job)
+	data := snapshot(job)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	s.logger.Infof("Queued job [%s]: %s", id, req.URL)
+	w.Header().Set("Location", "/jobs/"+id)
+	writeJSON(w, http.StatusCreated, data)
+}
+
+func (s *Server) handleList(w http.ResponseWriter) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, snapshot(job))
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	job, ok := s.byID[id]
+	var data Job
+	if ok {
+		data = snapshot(job)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	job, ok := s.byID[id]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	switch job.Status {
+	case StatusQueued:
+		now := time.Now().UTC()
+		job.Status, job.Finished = StatusCancelled, &now
+		s.changed(job)
+	case StatusRunning:
+		// The job stops before its next item
+		s.cancels[job.ID]()
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Sprintf("job is %s", job.Status))
+		return
+	}
+	data := snapshot(job)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusAccepted, data)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	s.mu.Lock()
+	job, ok := s.byID[id]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	data, _ := json.Marshal(snapshot(job))
+	var ch chan []byte
+	if !job.finished() {
+		ch = make(chan []byte, 64)
+		if s.subscribers[id] == nil {
+			s.subscribers[id] = make(map[chan []byte]bool)
+		}
+		s.subscribers[id][ch] = true
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+	flusher.Flush()
+	if ch == nil {
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers[id], ch)
+		s.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			_, _ = w.Write(event)
+			flusher.Flush()
+		case <-ticker.C:
+			_, _ = w.Write([]byte(": keep-alive\n\n"))
+			flusher.Flush()
+		}
+	}
+}
+
+// jobFile is a downloaded file of a job, fetched by the ID of its item.
+type jobFile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func (s *Server) handleFiles(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	job, ok := s.byID[id]
+	var items []spotify.HookItem
+	if ok {
+		items = append(items, job.Items...)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	files := make([]jobFile, 0, len(items))
+	for _, item := range items {
+		if item.Error != "" || item.Path == "" {
+			continue
+		}
+		info, err := os.Stat(item.Path)
+		if err != nil {
+			continue
+		}
+		files = append(files, jobFile{ID: item.ID, Name: filepath.Base(item.Path), Size: info.Size()})
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, id string, itemID string) {
+	s.mu.Lock()
+	var item spotify.HookItem
+	if job, ok := s.byID[id]; ok {
+		if i := findItem(job.Items, itemID); i >= 0 {
+			item = job.Items[i]
+		}
+	}
+	s.mu.Unlock()
+	if item.Path == "" || item.Error != "" {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+
+	f, err := os.Open(item.Path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	name := filepath.Base(item.Path)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func validType(idType spotify.IDType) bool {
+	switch idType {
+	case spotify.TRACK, spotify.ALBUM, spotify.PLAYLIST, spotify.SHOW, spotify.EPISODE, spotify.ARTIST:
+		return true
+	}
+	return false
+}
+
+// snapshot copies job, so that it can be encoded without holding s.mu.
+func snapshot(job *Job) Job {
+	c := *job
+	c.Items = append([]spotify.HookItem(nil), job.Items...)
+	return c
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,97 @@
+package spotify
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ArchiveEntry records a downloaded item in an archive file.
+type ArchiveEntry struct {
+	ID         string    `json:"id"`
+	Type       IDType    `json:"type"`
+	Path       string    `json:"path"`
+	DurationMS int       `json:"duration_ms,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// SetArchive records every downloaded item in the archive file at path, one
+// JSON object per line, so that the library can be checked later with
+// ReadArchive and VerifyFile. An empty path disables the archive.
+func (d *Downloader) SetArchive(path string) *Downloader {
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+	d.archivePath = path
+	return d
+}
+
+func (d *Downloader) addToArchive(entry ArchiveEntry) error {
+	if d.archivePath == "" {
+		return nil
+	}
+	if path, err := filepath.Abs(entry.Path); err == nil {
+		entry.Path = path
+	}
+	entry.Time = time.Now().UTC()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(d.archivePath); dir != "." {
+		if err := checkDirExist(dir); err != nil {
+			return err
+		}
+	}
+	unlock, err := fileutil.Lock(d.archivePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(d.archivePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return errors.Join(err, f.Close())
+}
+
+// ReadArchive reads the archive file at path. An item downloaded more than
+// once is returned once, with its latest entry, in the order items were
+// first downloaded.
+func ReadArchive(path string) ([]ArchiveEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []ArchiveEntry
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry ArchiveEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		key := string(entry.Type) + ":" + entry.ID
+		if i, ok := index[key]; ok {
+			entries[i] = entry
+			continue
+		}
+		index[key] = len(entries)
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,247 @@
+package spotify
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataCache keeps raw API responses in memory and, when a folder is
+// set, on disk until they exceed the TTL.
+type metadataCache struct {
+	mu     sync.Mutex
+	mem    map[string]cacheEntry
+	swept  time.Time
+	dir    string
+	ttl    time.Duration
+	logger *log.Logger
+}
+
+// cacheEntry is a response kept in memory and when it was fetched.
+type cacheEntry struct {
+	data []byte
+	time time.Time
+}
+
+func newMetadataCache(logger *log.Logger) *metadataCache {
+	return &metadataCache{
+		mem:    make(map[string]cacheEntry),
+		swept:  time.Now(),
+		ttl:    24 * time.Hour,
+		logger: logger,
+	}
+}
+
+func (c *metadataCache) expired(t time.Time) bool {
+	return c.ttl > 0 && time.Since(t) > c.ttl
+}
+
+// sweep drops the expired entries from memory, at most once a minute.
+func (c *metadataCache) sweep() {
+	if time.Since(c.swept) < time.Minute {
+		return
+	}
+	c.swept = time.Now()
+	for key, entry := range c.mem {
+		if c.expired(entry.time) {
+			delete(c.mem, key)
+		}
+	}
+}
+
+func (c *metadataCache) filePath(key string) string {
+	return filepath.Join(c.dir, strings.ReplaceAll(key, ":", "_")+".json")
+}
+
+func (c *metadataCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.mem[key]; ok {
+		if !c.expired(entry.time) {
+			return entry.data, true
+		}
+		delete(c.mem, key)
+	}
+	if c.dir == "" {
+		return nil, false
+	}
+
+	filePath := c.filePath(key)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, false
+	}
+	if c.expired(info.ModTime()) {
+		c.logger.Debugf("Cached metadata [%s] expired", key)
+		_ = os.Remove(filePath)
+		return nil, false
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, false
+	}
+	c.mem[key] = cacheEntry{data: data, time: info.ModTime()}
+	return data, true
+}
+
+func (c *metadataCache) set(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep()
+	c.mem[key] = cacheEntry{data: data, time: time.Now()}
+	if c.dir == "" {
+		return
+	}
+	if err := checkDirExist(c.dir); err != nil {
+		c.logger.Warnf("Failed to create metadata cache folder: %v", err)
+		return
+	}
+	tmpFilePath := c.filePath(key) + ".tmp"
+	if err := os.WriteFile(tmpFilePath, data, 0644); err != nil {
+		c.logger.Warnf("Failed to write metadata cache: %v", err)
+		return
+	}
+	if err := os.Rename(tmpFilePath, c.filePath(key)); err != nil {
+		_ = os.Remove(tmpFilePath)
+		c.logger.Warnf("Failed to write metadata cache: %v", err)
+	}
+}
+
+// cachedRequest performs a GET request unless a response for key is cached.
+func (d *Downloader) cachedRequest(key, url string) ([]byte, error) {
+	if data, ok := d.metadataCache.get(key); ok {
+		d.logger.Debugf("Using cached metadata [%s]", key)
+		return data, nil
+	}
+	data, err := d.makeRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.metadataCache.set(key, data)
+	return data, nil
+}
+
+// uncachedRequest performs a GET request and caches the response for key,
+// for callers that must see the current state of an item.
+func (d *Downloader) uncachedRequest(key, url string) ([]byte, error) {
+	data, err := d.makeRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.metadataCache.set(key, data)
+	return data, nil
+}
+
+// PrefetchMetadata loads the web API track and album data of trackIDs with
+// the bulk endpoints, so that tagging a whole album or playlist does not
+// request the same data once per track.
+func (d *Downloader) PrefetchMetadata(trackIDs []string) error {
+	var missing []string
+	for _, id := range trackIDs {
+		if _, ok := d.metadataCache.get(d.trackCacheKey(id)); !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	for _, ids := range chunkIDs(missing, 50) {
+		if _, err := d.queryTracksAPI(ids); err != nil {
+			return fmt.Errorf("failed to prefetch tracks: %w", err)
+		}
+	}
+
+	albumIDs := make(map[string]bool)
+	for _, id := range trackIDs {
+		data, ok := d.metadataCache.get(d.trackCacheKey(id))
+		if !ok {
+			continue
+		}
+		var track trackData
+		if err := json.Unmarshal(data, &track); err == nil && track.Album.ID != "" {
+			albumIDs[track.Album.ID] = true
+		}
+	}
+
+	var missingAlbums []string
+	for id := range albumIDs {
+		if _, ok := d.metadataCache.get(d.albumCacheKey(id)); !ok {
+			missingAlbums = append(missingAlbums, id)
+		}
+	}
+	for _, ids := range chunkIDs(missingAlbums, 20) {
+		if _, err := d.queryAlbumsAPI(ids); err != nil {
+			return fmt.Errorf("failed to prefetch albums: %w", err)
+		}
+	}
+
+	d.logger.Debugf("Prefetched %d track(s) and %d album(s)", len(missing), len(missingAlbums))
+	return nil
+}
+
+func chunkIDs(ids []string, size int) [][]string {
+	var chunks [][]string
+	for size < len(ids) {
+		ids, chunks = ids[size:], append(chunks, ids[:size])
+	}
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+	return chunks
+}
+
+// cacheKey returns the cache key of the item id of kind. Responses are in
+// the languages requested, so keys include them, and profiles with
+// different languages sharing a cache folder do not get each other's
+// titles.
+func (d *Downloader) cacheKey(kind, id string) string {
+	key := kind + ":" + id
+	if locale := d.cacheLocale(); locale != "" {
+		key += "@" + locale
+	}
+	return key
+}
+
+var unsafeLocaleChars = regexp.MustCompile(`[^a-z0-9-]`)
+
+// cacheLocale returns the requested languages in a form safe for file
+// names, e.g. "ja+en-us", or "" if none are set.
+func (d *Downloader) cacheLocale() string {
+	var tags []string
+	for _, lang := range d.acceptLanguage() {
+		tag, _, _ := strings.Cut(lang, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "*" {
+			tag = "any"
+		}
+		tags = append(tags, unsafeLocaleChars.ReplaceAllString(tag, "_"))
+	}
+	return strings.Join(tags, "+")
+}
+
+func (d *Downloader) trackCacheKey(trackID string) string {
+	return d.cacheKey("track", trackID)
+}
+
+func (d *Downloader) albumCacheKey(albumID string) string {
+	return d.cacheKey("album", albumID)
+}
+
+func (d *Downloader) artistCacheKey(artistID string) string {
+	return d.cacheKey("artist", artistID)
+}
+
+func (d *Downloader) creditsCacheKey(trackID string) string {
+	return d.cacheKey("credits", trackID)
+}
+
+func (d *Downloader) trackMetadataCacheKey(trackID string) string {
+	return d.cacheKey("metadata", trackID)
+}
@@ -0,0 +1,199 @@
+package spotify
+
+import (
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	CodecMP3  = "mp3"
+	CodecAAC  = "aac"
+	CodecOpus = "opus"
+	CodecFLAC = "flac"
+)
+
+type codecInfo struct {
+	extension  string
+	format     string
+	encoder    string
+	lossless   bool
+	minBitrate int
+	maxBitrate int
+	// sampleRates lists the only rates the encoder accepts, if limited.
+	sampleRates []int
+	maxChannels int
+}
+
+var codecs = map[string]codecInfo{
+	CodecMP3:  {extension: "mp3", format: "mp3", encoder: "libmp3lame", minBitrate: 8, maxBitrate: 320, maxChannels: 2},
+	CodecAAC:  {extension: "m4a", format: "ipod", encoder: "aac", minBitrate: 16, maxBitrate: 512, maxChannels: 8},
+	CodecOpus: {extension: "opus", format: "opus", encoder: "libopus", minBitrate: 6, maxBitrate: 510, sampleRates: []int{8000, 12000, 16000, 24000, 48000}, maxChannels: 8},
+	CodecFLAC: {extension: "flac", format: "flac", encoder: "flac", lossless: true, maxChannels: 8},
+}
+
+// ConvertTarget describes the format downloaded files are converted to.
+type ConvertTarget struct {
+	// Codec is one of CodecMP3, CodecAAC, CodecOpus and CodecFLAC.
+	Codec string
+	// Bitrate in kbit/s of lossy codecs. Zero matches the bitrate of the
+	// downloaded quality.
+	Bitrate int
+	// VBR encodes mp3 with variable bitrate at VBRQuality, from 0 (best) to
+	// 9, instead of a constant bitrate.
+	VBR        bool
+	VBRQuality int
+	// SampleRate in Hz and number of Channels; zero keeps those of the
+	// download.
+	SampleRate int
+	Channels   int
+}
+
+// ParseConvertTarget parses a codec name optionally followed by a colon and
+// comma-separated options, e.g. "mp3", "mp3:vbr=0" or
+// "opus:bitrate=128,rate=48000,channels=2".
+func ParseConvertTarget(spec string) (ConvertTarget, error) {
+	codec, options, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	target := ConvertTarget{Codec: strings.ToLower(codec)}
+	if options != "" {
+		for _, option := range strings.Split(options, ",") {
+			key, value, ok := strings.Cut(option, "=")
+			if !ok {
+				return target, fmt.Errorf("invalid convert option %q, expected key=value", option)
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "k"))
+			if err != nil {
+				return target, fmt.Errorf("invalid value of convert option %s: %s", key, value)
+			}
+			switch strings.TrimSpace(key) {
+			case "bitrate":
+				target.Bitrate = n
+			case "vbr":
+				target.VBR, target.VBRQuality = true, n
+			case "rate":
+				target.SampleRate = n
+			case "channels":
+				target.Channels = n
+			default:
+				return target, fmt.Errorf("unknown convert option %q, expected bitrate, vbr, rate or channels", key)
+			}
+		}
+	}
+	return target, target.validate()
+}
+
+func (t ConvertTarget) validate() error {
+	info, ok := codecs[t.Codec]
+	if !ok {
+		return fmt.Errorf("%q is not a supported conversion codec, expected mp3, aac, opus or flac", t.Codec)
+	}
+	switch {
+	case t.Bitrate != 0 && info.lossless:
+		return fmt.Errorf("%s is lossless and takes no bitrate", t.Codec)
+	case t.Bitrate != 0 && (t.Bitrate < info.minBitrate || t.Bitrate > info.maxBitrate):
+		return fmt.Errorf("%s bitrate must be between %dk and %dk", t.Codec, info.minBitrate, info.maxBitrate)
+	case t.VBR && t.Codec != CodecMP3:
+		return fmt.Errorf("variable bitrate quality is only supported for mp3")
+	case t.VBR && t.Bitrate != 0:
+		return fmt.Errorf("mp3 takes either a bitrate or a vbr quality, not both")
+	case t.VBR && (t.VBRQuality < 0 || t.VBRQuality > 9):
+		return fmt.Errorf("mp3 vbr quality must be between 0 and 9")
+	case t.SampleRate < 0 || t.Channels < 0:
+		return fmt.Errorf("sample rate and channels must not be negative")
+	case t.Channels > info.maxChannels:
+		return fmt.Errorf("%s supports at most %d channels", t.Codec, info.maxChannels)
+	}
+	if t.SampleRate != 0 && len(info.sampleRates) > 0 {
+		for _, rate := range info.sampleRates {
+			if rate == t.SampleRate {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s does not support a sample rate of %d Hz", t.Codec, t.SampleRate)
+	}
+	return nil
+}
+
+// Extension returns the file extension, without the dot, of converted files.
+func (t ConvertTarget) Extension() string {
+	return codecs[t.Codec].extension
+}
+
+// qualityBitrate returns the bitrate in kbit/s of a download quality.
+func qualityBitrate(quality string) int {
+	switch quality {
+	case Quality96Vorbis:
+		return 96
+	case Quality128MP4, Quality128MP4Dual:
+		return 128
+	case Quality160Vorbis:
+		return 160
+	case Quality256MP4, Quality256MP4Dual:
+		return 256
+	case Quality320Vorbis:
+		return 320
+	}
+	return 0
+}
+
+func (d *Downloader) convert(inputFile string, outputFile string) (err error) {
+	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+		return fmt.Errorf(`input file [%s] not exists`, inputFile)
+	}
+
+	// ffmpeg cannot write to the file it reads from, e.g. when converting
+	// an m4a download to aac
+	if inputFile == outputFile {
+		srcFile := inputFile + ".src"
+		if err := os.Rename(inputFile, srcFile); err != nil {
+			return err
+		}
+		defer os.Remove(srcFile)
+		inputFile = srcFile
+	}
+
+	target := *d.convertTarget
+	info := codecs[target.Codec]
+	d.logger.Debugf("Converting [%s] to [%s] with %+v", inputFile, outputFile, target)
+
+	args := ffmpeg.KwArgs{
+		"format": info.format,
+		"c:a":    info.encoder,
+	}
+	switch {
+	case info.lossless:
+	case target.VBR:
+		args["q:a"] = strconv.Itoa(target.VBRQuality)
+	default:
+		bitrate := target.Bitrate
+		if bitrate == 0 {
+			bitrate = min(max(qualityBitrate(d.quality), info.minBitrate), info.maxBitrate)
+		}
+		args["audio_bitrate"] = fmt.Sprintf("%dk", bitrate)
+	}
+	if target.SampleRate != 0 {
+		args["ar"] = strconv.Itoa(target.SampleRate)
+	}
+	if target.Channels != 0 {
+		args["ac"] = strconv.Itoa(target.Channels)
+	}
+
+	ff := ffmpeg.Input(inputFile).
+		Output(outputFile, args).
+		OverWriteOutput().Silent(true)
+
+	if d.logger.Enabled(log.LevelDebug) {
+		ff.Silent(false).WithErrorOutput(os.Stderr)
+	}
+
+	err = ff.Run()
+	if err != nil {
+		return fmt.Errorf("error while converting to %s: %v", target.Codec, err)
+	}
+	d.logger.Debugln("Convert successfully")
+
+	return
+}
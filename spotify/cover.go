@@ -1,36 +1,247 @@
 package spotify
 
 import (
+	"errors"
 	"fmt"
-	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 )
 
-func (d *Downloader) downloadCoverImage(metadata trackMetadata) (fileName string, err error) {
-	fileId, err := getLargestCover(metadata)
+const (
+	CoverSizeLargest   = "largest"
+	coverSizeMaxPrefix = "max:"
+)
+
+type coverImage struct {
+	ID     string
+	URL    string
+	Width  int
+	Height int
+}
+
+// coverSize selects one image out of the sizes offered for an artwork:
+// the largest one, the one closest to a given width, or the largest one
+// whose longer side does not exceed a maximum dimension.
+type coverSize struct {
+	width        int
+	maxDimension int
+}
+
+func parseCoverSize(spec string) (coverSize, error) {
+	switch {
+	case spec == "" || spec == CoverSizeLargest:
+		return coverSize{}, nil
+	case strings.HasPrefix(spec, coverSizeMaxPrefix):
+		dim, err := strconv.Atoi(strings.TrimPrefix(spec, coverSizeMaxPrefix))
+		if err != nil || dim <= 0 {
+			return coverSize{}, fmt.Errorf("invalid max cover dimension: %s", spec)
+		}
+		return coverSize{maxDimension: dim}, nil
+	default:
+		width, err := strconv.Atoi(spec)
+		if err != nil || width <= 0 {
+			return coverSize{}, fmt.Errorf("%s is not a valid cover size", spec)
+		}
+		return coverSize{width: width}, nil
+	}
+}
+
+func (s coverSize) selectFrom(images []coverImage) (coverImage, error) {
+	if len(images) == 0 {
+		return coverImage{}, fmt.Errorf("no cover images available")
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Width*images[i].Height > images[j].Width*images[j].Height
+	})
+
+	switch {
+	case s.width > 0:
+		best := images[0]
+		for _, img := range images[1:] {
+			if abs(img.Width-s.width) < abs(best.Width-s.width) {
+				best = img
+			}
+		}
+		return best, nil
+	case s.maxDimension > 0:
+		for _, img := range images {
+			if max(img.Width, img.Height) <= s.maxDimension {
+				return img, nil
+			}
+		}
+		return images[len(images)-1], nil
+	default:
+		return images[0], nil
+	}
+}
+
+func trackCoverImages(metadata trackMetadata) []coverImage {
+	images := make([]coverImage, 0, len(metadata.Album.CoverGroup.Image))
+	for _, img := range metadata.Album.CoverGroup.Image {
+		images = append(images, coverImage{
+			ID:     img.FileId,
+			URL:    fmt.Sprintf("https://i.scdn.co/image/%s", img.FileId),
+			Width:  img.Width,
+			Height: img.Height,
+		})
+	}
+	return images
+}
+
+func episodeCoverImages(metadata episodeMetadata) []coverImage {
+	coverArt := metadata.Data.Episode.CoverArt
+	if len(coverArt.Sources) == 0 {
+		coverArt = metadata.Data.Episode.Podcast.Data.CoverArt
+	}
+
+	images := make([]coverImage, 0, len(coverArt.Sources))
+	for _, src := range coverArt.Sources {
+		images = append(images, coverImage{
+			ID:     path.Base(src.URL),
+			URL:    src.URL,
+			Width:  src.Width,
+			Height: src.Height,
+		})
+	}
+	return images
+}
+
+// coverFetch is a cover being downloaded, which callers wanting the same
+// cover wait for.
+type coverFetch struct {
+	done     chan struct{}
+	filePath string
+	err      error
+}
+
+// downloadCoverImage returns the path of the selected cover in the cover
+// cache, downloading it only when no earlier track has fetched it yet.
+// Different covers are downloaded at the same time, and callers wanting a
+// cover already being downloaded wait for that download.
+func (d *Downloader) downloadCoverImage(images []coverImage) (filePath string, err error) {
+	d.logger.Debugf("Cover image: %+v", images)
+	img, err := d.coverSize.selectFrom(images)
+	if err != nil {
+		return "", fmt.Errorf("failed to get cover: %v", err)
+	}
+
+	d.coversMu.Lock()
+	if filePath, ok := d.covers[img.ID]; ok {
+		d.coversMu.Unlock()
+		return filePath, nil
+	}
+	if fetch, ok := d.coverFetches[img.ID]; ok {
+		d.coversMu.Unlock()
+		<-fetch.done
+		return fetch.filePath, fetch.err
+	}
+	fetch := &coverFetch{done: make(chan struct{})}
+	d.coverFetches[img.ID] = fetch
+	d.coversMu.Unlock()
+
+	fetch.filePath, fetch.err = d.fetchCoverImage(img)
+
+	d.coversMu.Lock()
+	delete(d.coverFetches, img.ID)
+	if fetch.err == nil {
+		d.covers[img.ID] = fetch.filePath
+	}
+	d.coversMu.Unlock()
+	close(fetch.done)
+	return fetch.filePath, fetch.err
+}
+
+// fetchCoverImage downloads img to the cover cache unless it is there.
+func (d *Downloader) fetchCoverImage(img coverImage) (string, error) {
+	if err := checkDirExist(d.coverCacheDir); err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(d.coverCacheDir, fmt.Sprintf("%s.jpg", img.ID))
+
+	if info, err := os.Stat(filePath); err == nil && info.Size() > 0 {
+		d.logger.Debugf("Using cached cover [%s]", filePath)
+		return filePath, nil
+	}
+	// A temporary file of its own keeps other processes sharing the cache
+	// from writing to the same file
+	tmpFile, err := os.CreateTemp(d.coverCacheDir, img.ID+"-*.tmp")
 	if err != nil {
-		return fileName, fmt.Errorf("failed to get cover: %v", err)
+		return "", fmt.Errorf("failed to save cover: %v", err)
 	}
+	tmpFilePath := tmpFile.Name()
+	_ = tmpFile.Close()
+	if err := d.downloadURL(img.URL, tmpFilePath); err != nil {
+		_ = os.Remove(tmpFilePath)
+		return "", err
+	}
+	if err := os.Rename(tmpFilePath, filePath); err != nil {
+		_ = os.Remove(tmpFilePath)
+		return "", fmt.Errorf("failed to save cover: %v", err)
+	}
+	return filePath, nil
+}
 
-	url := fmt.Sprintf("https://i.scdn.co/image/%s", fileId)
-	fileName = fmt.Sprintf("%s.jpg", fileId)
+// defaultCoverCacheDir returns the folder covers are cached in if none is
+// set: in the user cache directory, or the temp directory if there is none.
+func defaultCoverCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "sp-dl-go", "covers")
+}
 
-	if err = d.downloadURL(url, fileName); err != nil {
-		return
+// saveCoverFiles copies the cover next to the downloaded file under each of
+// the configured sidecar names, leaving existing artwork untouched.
+func (d *Downloader) saveCoverFiles(images []coverImage, dir string) error {
+	coverFilePath, err := d.downloadCoverImage(images)
+	if err != nil {
+		return err
 	}
-	return
+
+	for _, name := range d.coverFiles {
+		dst := filepath.Join(dir, name)
+		if _, err := os.Stat(dst); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := copyFile(coverFilePath, dst); err != nil {
+			return fmt.Errorf("failed to save cover file [%s]: %v", dst, err)
+		}
+		d.logger.Debugf("Saved cover file [%s]", dst)
+		if err := d.addToManifest(dst, ManifestEntry{}); err != nil {
+			d.logger.Warnf("Failed to add [%s] to the manifest: %v", dst, err)
+		}
+	}
+	return nil
 }
 
-func getLargestCover(metadata trackMetadata) (string, error) {
-	if len(metadata.Album.CoverGroup.Image) == 0 {
-		return "", fmt.Errorf("no cover images available")
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
 	}
-	log.Debugf("Cover image: %+v", metadata.Album.CoverGroup.Image)
+	defer in.Close()
 
-	sort.Slice(metadata.Album.CoverGroup.Image, func(i, j int) bool {
-		return metadata.Album.CoverGroup.Image[i].Width*metadata.Album.CoverGroup.Image[i].Height >
-			metadata.Album.CoverGroup.Image[j].Width*metadata.Album.CoverGroup.Image[j].Height
-	})
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
 
-	return metadata.Album.CoverGroup.Image[0].FileId, nil
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
 }
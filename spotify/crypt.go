@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	log "github.com/XiaoMengXinX/sp-dl-go/logger"
 	"github.com/XiaoMengXinX/sp-dl-go/playplay"
 	widevine "github.com/iyear/gowidevine"
 	"github.com/iyear/gowidevine/widevinepb"
@@ -14,7 +13,7 @@ import (
 	"net/http"
 )
 
-func requestPSSH(fildID string) (pssh string, err error) {
+func (d *Downloader) requestPSSH(fildID string) (pssh string, err error) {
 	url := fmt.Sprintf("https://seektables.scdn.co/seektable/%s.json", fildID)
 
 	resp, err := http.Get(url)
@@ -34,7 +33,7 @@ func requestPSSH(fildID string) (pssh string, err error) {
 
 	pssh, ok := result["pssh"].(string)
 	if !ok {
-		log.Debugf("Failed to find PSSH: %+v", result)
+		d.logger.Debugf("Failed to find PSSH: %+v", result)
 		return "", fmt.Errorf("PSSH not found")
 	}
 
@@ -104,12 +103,12 @@ func (d *Downloader) getOggKeys(fileID string) (key [16]byte, err error) {
 	hexFileID, _ := hex.DecodeString(fileID)
 	obfuscatedKey := [16]byte(playplayResponse.GetObfuscatedKey()[:])
 
-	log.Debugf("[OGG Crypt] file id: %x", hexFileID)
-	log.Debugf("[OGG Crypt] obfuscated key: %x", obfuscatedKey)
+	d.logger.Debugf("[OGG Crypt] file id: %x", hexFileID)
+	d.logger.Debugf("[OGG Crypt] obfuscated key: %x", obfuscatedKey)
 
 	key = playplay.PlayPlayDecrypt(obfuscatedKey, [20]byte(hexFileID[:]))
 
-	log.Debugf("[OGG Crypt] deobfuscated key: %x", key)
+	d.logger.Debugf("[OGG Crypt] deobfuscated key: %x", key)
 
 	return key, nil
 }
@@ -6,6 +6,16 @@ type WebAPITrackInfo struct {
 	DurationMS int
 	Name       string
 	URL        string
+	Explicit   bool
+	Popularity int
+	Genres     []string
+	Credits    WebAPICredits
+}
+
+type WebAPICredits struct {
+	Composers []string
+	Lyricists []string
+	Producers []string
 }
 
 type WebAPIAlbumInfo struct {
@@ -48,9 +58,30 @@ type playlistTracksData struct {
 	Next   string `json:"next"`
 }
 
+type playlistData struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	SnapshotID string `json:"snapshot_id"`
+}
+
+type artistAlbumsData struct {
+	Items []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"items"`
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Next   string `json:"next"`
+}
+
 type showTracksData struct {
 	Items []struct {
-		Id string `json:"id"`
+		Id          string `json:"id"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		ReleaseDate string `json:"release_date"`
+		DurationMS  int    `json:"duration_ms"`
+		Explicit    bool   `json:"explicit"`
 	} `json:"items"`
 	Total  int    `json:"total"`
 	Offset int    `json:"offset"`
@@ -82,6 +113,20 @@ type albumData struct {
 	Label  string   `json:"label"`
 }
 
+type showData struct {
+	ExternalUrls struct {
+		Spotify string `json:"spotify"`
+	} `json:"external_urls"`
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Publisher     string           `json:"publisher"`
+	Description   string           `json:"description"`
+	Languages     []string         `json:"languages"`
+	Explicit      bool             `json:"explicit"`
+	Images        []albumImageData `json:"images"`
+	TotalEpisodes int              `json:"total_episodes"`
+}
+
 type trackData struct {
 	ExternalUrls struct {
 		Spotify string `json:"spotify"`
@@ -94,13 +139,41 @@ type trackData struct {
 		EAN  string `json:"ean"`
 		UPC  string `json:"upc"`
 	} `json:"external_ids"`
+	ID          string `json:"id"`
 	Name        string `json:"name"`
 	TrackNumber int    `json:"track_number"`
+	Explicit    bool   `json:"explicit"`
+	Popularity  int    `json:"popularity"`
+}
+
+type artistDetailData struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Genres []string `json:"genres"`
+}
+
+type trackCreditsData struct {
+	TrackURI    string `json:"trackUri"`
+	RoleCredits []struct {
+		RoleTitle string `json:"roleTitle"`
+		Artists   []struct {
+			URI      string   `json:"uri"`
+			Name     string   `json:"name"`
+			Subroles []string `json:"subroles"`
+		} `json:"artists"`
+	} `json:"roleCredits"`
 }
 
 type trackMetadata struct {
-	GID   string `json:"gid"`
-	Name  string `json:"name"`
+	GID        string `json:"gid"`
+	Name       string `json:"name"`
+	Number     int    `json:"number"`
+	DiscNumber int    `json:"disc_number"`
+	Duration   int    `json:"duration"`
+	ExternalID []struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+	} `json:"external_id"`
 	Album struct {
 		Name       string `json:"name"`
 		CoverGroup struct {
@@ -118,20 +191,44 @@ type trackMetadata struct {
 type episodeMetadata struct {
 	Data struct {
 		Episode struct {
-			Name    string `json:"name"`
-			Creator string `json:"creator"`
-			Audio   struct {
+			ID          string `json:"id"`
+			URI         string `json:"uri"`
+			Name        string `json:"name"`
+			Creator     string `json:"creator"`
+			Description string `json:"description"`
+			ReleaseDate struct {
+				IsoString string `json:"isoString"`
+				Precision string `json:"precision"`
+			} `json:"releaseDate"`
+			Duration struct {
+				TotalMilliseconds int `json:"totalMilliseconds"`
+			} `json:"duration"`
+			CoverArt coverArtData `json:"coverArt"`
+			Audio    struct {
 				Items []fileEntry `json:"items"`
 			} `json:"audio"`
 			Podcast struct {
 				Data struct {
-					Name string `json:"name"`
+					Name      string `json:"name"`
+					URI       string `json:"uri"`
+					Publisher struct {
+						Name string `json:"name"`
+					} `json:"publisher"`
+					CoverArt coverArtData `json:"coverArt"`
 				} `json:"data"`
 			} `json:"podcastV2"`
 		} `json:"episodeUnionV2"`
 	} `json:"data"`
 }
 
+type coverArtData struct {
+	Sources []struct {
+		URL    string `json:"url"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+	} `json:"sources"`
+}
+
 type artistData struct {
 	ExternalUrls struct {
 		Spotify string `json:"spotify"`
@@ -1,6 +1,8 @@
 package spotify
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/XiaoMengXinX/SimpleDownloader"
 	log "github.com/XiaoMengXinX/sp-dl-go/logger"
@@ -10,9 +12,11 @@ import (
 	"path/filepath"
 )
 
-func (d *Downloader) downloadContent(ID string, content IDType) (outFilePath string, err error) {
+func (d *Downloader) downloadContent(ID string, content IDType) (outFilePath string, reused bool, err error) {
 	var name, artist, fileID, format string
 	var metadata trackMetadata
+	var episodeMD episodeMetadata
+	l := d.logger.With("id", ID, "type", string(content))
 
 	switch content {
 	case TRACK:
@@ -20,23 +24,23 @@ func (d *Downloader) downloadContent(ID string, content IDType) (outFilePath str
 		if err != nil {
 			defer func(ID string, err *error) {
 				if *err != nil {
-					log.Errorln((*err).Error())
+					l.Errorln((*err).Error())
 				}
 			}(ID, &err)
-			return outFilePath, fmt.Errorf("failed to get metadata of trackID [%s]: %v", ID, err)
+			return outFilePath, false, fmt.Errorf("failed to get metadata of trackID [%s]: %v", ID, err)
 		}
 	case EPISODE:
-		name, artist, fileID, _, err = d.getEpisodeMetadata(ID)
+		name, artist, fileID, episodeMD, err = d.getEpisodeMetadata(ID)
 		if err != nil {
 			defer func(ID string, err *error) {
 				if *err != nil {
-					log.Errorln((*err).Error())
+					l.Errorln((*err).Error())
 				}
 			}(ID, &err)
-			return outFilePath, fmt.Errorf("failed to get metadata of episodeID [%s]: %v", ID, err)
+			return outFilePath, false, fmt.Errorf("failed to get metadata of episodeID [%s]: %v", ID, err)
 		}
 	default:
-		return outFilePath, fmt.Errorf("invalid content type")
+		return outFilePath, false, fmt.Errorf("invalid content type")
 	}
 
 	switch {
@@ -46,63 +50,222 @@ func (d *Downloader) downloadContent(ID string, content IDType) (outFilePath str
 		format = "ogg"
 	}
 
-	fileName := cleanFilename(fmt.Sprintf("%s - %s", name, artist))
+	fields := map[string]string{
+		"id":     ID,
+		"name":   name,
+		"artist": artist,
+	}
+	switch content {
+	case TRACK:
+		fields["album"] = metadata.Album.Name
+		fields["track"] = fmt.Sprintf("%02d", metadata.Number)
+		fields["disc"] = fmt.Sprintf("%d", metadata.DiscNumber)
+	case EPISODE:
+		fields["album"] = episodeMD.Data.Episode.Podcast.Data.Name
+	}
+
+	fileName := d.claimFilename(formatOutputPath(d.outputTemplate, fields, d.filenamePolicy), ID)
 	outFilePath = fmt.Sprintf("%s.%s", filepath.Join(d.outputFolder, fileName), format)
 
-	log.Infof("Downloading %s [%s]", content, fileName)
+	l = l.With("file", fileName)
+
+	var durationMS int
+	var isrc string
+	switch content {
+	case TRACK:
+		durationMS = metadata.Duration
+		isrc = metadata.isrc()
+	case EPISODE:
+		durationMS = episodeMD.Data.Episode.Duration.TotalMilliseconds
+	}
+
+	payload := HookPayload{
+		Type:   content,
+		ID:     ID,
+		Name:   name,
+		Artist: artist,
+		Album:  fields["album"],
+	}
+	if content == TRACK {
+		payload.Track = metadata.Number
+		payload.Disc = metadata.DiscNumber
+	}
+
+	if d.dedupPolicy != DedupOff {
+		ext := format
+		if hasFFmpeg && d.convertTarget != nil {
+			ext = d.convertTarget.Extension()
+		}
+		if entry, ok := d.findInLibrary(ID, isrc, fileID, ext); ok {
+			path, err := d.reuseLibraryFile(entry.Path, fmt.Sprintf("%s.%s", filepath.Join(d.outputFolder, fileName), ext))
+			if err == nil {
+				l.Infof("Reused %s [%s] from the library: %s", content, fileName, entry.Path)
+				// A reused file is complete, so it goes through both stages
+				payload.Path = path
+				for _, stage := range []string{HookAfterDownload, HookAfterTagging} {
+					if err := d.runHooks(stage, payload); err != nil {
+						return path, false, err
+					}
+				}
+				d.recordDownload(l, LibraryEntry{ID: ID, Type: content, ISRC: isrc, FileID: fileID, Quality: entry.Quality, Path: entry.Path}, path, durationMS)
+				return path, true, nil
+			}
+			l.Warnf("Failed to reuse [%s], downloading it again: %v", entry.Path, err)
+		}
+	}
+
+	l.Infof("Downloading %s [%s]", content, fileName)
 
-	err = d.downloadAndDecrypt(fileName, format, fileID)
+	err = d.downloadAndDecrypt(l, fileName, format, fileID)
 	if err != nil {
-		return outFilePath, err
+		return outFilePath, false, err
 	}
 
 	defer func(filename string, err *error) {
 		if *err != nil {
-			log.Errorf("An error occurred while processing [%s]: %v", filename, (*err).Error())
+			l.Errorf("An error occurred while processing [%s]: %v", filename, (*err).Error())
 		}
 	}(fileName, &err)
 
-	if hasFFmpeg {
-		if d.isConvertToMP3 {
-			mp3FilePath := fmt.Sprintf("%s.mp3", filepath.Join(d.outputFolder, fileName))
-			err = d.convertMp3(outFilePath, mp3FilePath)
+	if hasFFmpeg && d.convertTarget != nil {
+		convertedFilePath := fmt.Sprintf("%s.%s", filepath.Join(d.outputFolder, fileName), d.convertTarget.Extension())
+		err = d.convert(outFilePath, convertedFilePath)
+		if convertedFilePath != outFilePath {
 			_ = os.Remove(outFilePath)
+		}
+		if err != nil {
+			_ = os.Remove(convertedFilePath)
+			return outFilePath, false, err
+		}
+
+		outFilePath = convertedFilePath
+	}
+
+	// Verify the download before any hook sees it, so that a download
+	// which is retried fires the hooks once
+	if err = d.verifyDownload(l, outFilePath, durationMS); err != nil {
+		return outFilePath, false, err
+	}
+
+	payload.Path = outFilePath
+	if err = d.runHooks(HookAfterDownload, payload); err != nil {
+		return outFilePath, false, err
+	}
+
+	if hasFFmpeg {
+		if !d.isSkipAddingMetadata {
+			switch content {
+			case TRACK:
+				err = d.addMetadata(metadata, outFilePath)
+			case EPISODE:
+				err = d.addEpisodeMetadata(episodeMD, outFilePath)
+			}
 			if err != nil {
-				_ = os.Remove(mp3FilePath)
-				return outFilePath, err
+				return outFilePath, false, err
 			}
-
-			outFilePath = mp3FilePath
 		}
 
-		if !d.isSkipAddingMetadata && (d.isConvertToMP3 || format == "m4a") && content == TRACK {
-			err = d.addMetadata(metadata, outFilePath)
-			if err != nil {
-				return outFilePath, err
+		if d.isReplayGain {
+			if err := d.addReplayGain(outFilePath); err != nil {
+				l.Warnf("Failed to add ReplayGain tags: %v", err)
 			}
 		}
 	} else {
-		if d.isConvertToMP3 {
-			log.Warnln("ffmpeg not found, skip converting to mp3")
+		if d.convertTarget != nil {
+			l.Warnf("ffmpeg not found, skip converting to %s", d.convertTarget.Codec)
 		}
 		if !d.isSkipAddingMetadata {
-			log.Warnln("ffmpeg not found, skip adding metadata")
+			l.Warnln("ffmpeg not found, skip adding metadata")
+		}
+		if d.isReplayGain {
+			l.Warnln("ffmpeg not found, skip adding ReplayGain tags")
 		}
 	}
 
-	log.Infof("Download %s [%s] successfully", content, fileName)
+	// Verify the file again as it ends up on disk, if tagging rewrote it
+	if hasFFmpeg && (!d.isSkipAddingMetadata || d.isReplayGain) {
+		if err = d.verifyDownload(l, outFilePath, durationMS); err != nil {
+			return outFilePath, false, err
+		}
+	}
+
+	if len(d.coverFiles) > 0 {
+		var images []coverImage
+		switch content {
+		case TRACK:
+			images = trackCoverImages(metadata)
+		case EPISODE:
+			images = episodeCoverImages(episodeMD)
+		}
+		if err := d.saveCoverFiles(images, filepath.Dir(outFilePath)); err != nil {
+			l.Warnf("Failed to save cover files: %v", err)
+		}
+	}
+
+	if err := d.addToManifest(outFilePath, ManifestEntry{ID: ID, FileID: fileID, Quality: d.quality}); err != nil {
+		l.Warnf("Failed to add [%s] to the manifest: %v", fileName, err)
+	}
+
+	if err = d.runHooks(HookAfterTagging, payload); err != nil {
+		return outFilePath, false, err
+	}
+
+	if err := d.addToArchive(ArchiveEntry{ID: ID, Type: content, Path: outFilePath, DurationMS: durationMS}); err != nil {
+		l.Warnf("Failed to add [%s] to the archive: %v", fileName, err)
+	}
+	if err := d.addToLibrary(LibraryEntry{ID: ID, Type: content, ISRC: isrc, FileID: fileID, Quality: d.quality, Path: outFilePath}); err != nil {
+		l.Warnf("Failed to add [%s] to the library index: %v", fileName, err)
+	}
+
+	l.Infof("Download %s [%s] successfully", content, fileName)
 	return
 }
 
-func (d *Downloader) downloadAndDecrypt(fileName string, format string, fileID string) (err error) {
-	tmpFileName := fmt.Sprintf("%s.%s.tmp", fileName, format)
-	tmpFilePath := filepath.Join(d.outputFolder, tmpFileName)
+// verifyDownload checks that filePath is playable and durationMS long if
+// verification is enabled, and removes it if not.
+func (d *Downloader) verifyDownload(l *log.Logger, filePath string, durationMS int) error {
+	if !d.isVerify {
+		return nil
+	}
+	err := VerifyFile(filePath, durationMS, d.verifyTolerance)
+	switch {
+	case errors.Is(err, ErrNoFFprobe):
+		l.Warnln("ffprobe not found, skip verifying downloaded files")
+		return nil
+	case err != nil:
+		_ = os.Remove(filePath)
+		return err
+	}
+	l.Debugf("Verified [%s]", filePath)
+	return nil
+}
+
+// recordDownload records an item reused from the library, found at path, in
+// the manifest, archive and library index.
+func (d *Downloader) recordDownload(l *log.Logger, entry LibraryEntry, path string, durationMS int) {
+	if path != entry.Path {
+		if err := d.addToManifest(path, ManifestEntry{ID: entry.ID, FileID: entry.FileID, Quality: entry.Quality}); err != nil {
+			l.Warnf("Failed to add [%s] to the manifest: %v", path, err)
+		}
+	}
+	if err := d.addToArchive(ArchiveEntry{ID: entry.ID, Type: entry.Type, Path: path, DurationMS: durationMS}); err != nil {
+		l.Warnf("Failed to add [%s] to the archive: %v", path, err)
+	}
+	if err := d.addToLibrary(entry); err != nil {
+		l.Warnf("Failed to add [%s] to the library index: %v", path, err)
+	}
+}
+
+func (d *Downloader) downloadAndDecrypt(l *log.Logger, fileName string, format string, fileID string) (err error) {
 	outFilePath := fmt.Sprintf("%s.%s", filepath.Join(d.outputFolder, fileName), format)
+	outDir := filepath.Dir(outFilePath)
+	tmpFileName := fmt.Sprintf("%s.tmp", filepath.Base(outFilePath))
+	tmpFilePath := filepath.Join(outDir, tmpFileName)
 
 	defer func(filename string, filePath string, err *error) {
 		if *err != nil {
 			_ = os.Remove(filePath)
-			log.Errorf("Failed to download [%s]: %v", filename, (*err).Error())
+			l.Errorf("Failed to download [%s]: %v", filename, (*err).Error())
 		}
 	}(fileName, outFilePath, &err)
 
@@ -111,7 +274,11 @@ func (d *Downloader) downloadAndDecrypt(fileName string, format string, fileID s
 		return err
 	}
 
-	dl := downloader.NewDownloader().SetSavePath(d.outputFolder).SetDownloadRoutine(4)
+	if err = checkDirExist(outDir); err != nil {
+		return err
+	}
+
+	dl := downloader.NewDownloader().SetSavePath(outDir).SetDownloadRoutine(4)
 	task, _ := dl.NewDownloadTask(cdnUrl)
 	err = task.SetFileName(tmpFileName).Download()
 	// err = d.downloadURL(cdnUrl, tmpFileName)
@@ -134,17 +301,17 @@ func (d *Downloader) downloadAndDecrypt(fileName string, format string, fileID s
 
 	switch format {
 	case "m4a":
-		PSSH, err := requestPSSH(fileID)
+		PSSH, err := d.requestPSSH(fileID)
 		if err != nil {
 			return err
 		}
-		log.Debugf("Request PSSH for [%s] successfully: %s", fileID, PSSH)
+		l.Debugf("Request PSSH for [%s] successfully: %s", fileID, PSSH)
 
 		keys, err := d.getMp4Keys(PSSH)
 		if err != nil {
 			return fmt.Errorf("get decrypt key failed: %v", err)
 		}
-		log.Debugf("Get decrypt key for [%s] successfully", fileID)
+		l.Debugf("Get decrypt key for [%s] successfully", fileID)
 
 		err = widevine.DecryptMP4Auto(tmpFile, keys, outFile)
 		if err != nil {
@@ -164,37 +331,142 @@ func (d *Downloader) downloadAndDecrypt(fileName string, format string, fileID s
 }
 
 func (d *Downloader) DownloadTrack(ID string) (downloadFilePath string, err error) {
-	return d.downloadContent(ID, TRACK)
+	downloadFilePath, _, err = d.downloadWithRetries(ID, TRACK)
+	return
 }
 
 func (d *Downloader) DownloadEpisode(ID string) (downloadFilePath string, err error) {
-	return d.downloadContent(ID, EPISODE)
+	downloadFilePath, _, err = d.downloadWithRetries(ID, EPISODE)
+	return
+}
+
+// downloadWithRetries downloads an item again, up to d.retries more times,
+// while it fails verification. reused is true if the file was reused from
+// the library instead of downloaded.
+func (d *Downloader) downloadWithRetries(ID string, content IDType) (downloadFilePath string, reused bool, err error) {
+	for attempt := 1; ; attempt++ {
+		downloadFilePath, reused, err = d.downloadContent(ID, content)
+		var verifyErr *VerifyError
+		if err == nil || attempt > d.retries || !errors.As(err, &verifyErr) {
+			return downloadFilePath, reused, err
+		}
+		d.logger.With("id", ID, "type", string(content)).Warnf("Retrying %s [%s] (%d/%d)", content, ID, attempt, d.retries)
+	}
 }
 
 func (d *Downloader) Download(url string) (err error) {
+	return d.DownloadContext(context.Background(), url)
+}
+
+// DownloadContext is Download, which stops before the next item once ctx is
+// done. Items not downloaded fail with the error of ctx.
+func (d *Downloader) DownloadContext(ctx context.Context, url string) (err error) {
 	tracks, err := d.GetTracks(url)
 	if err != nil {
 		return fmt.Errorf("failed to get tracks: %v", err)
 	}
 
 	if len(tracks) == 0 {
-		log.Info("No tracks to download")
+		d.logger.Info("No tracks to download")
 		return nil
 	}
 
-	log.Infof("Downloading %d track(s)", len(tracks))
+	d.logger.Infof("Downloading %d track(s)", len(tracks))
 
-	_, idType, _ := GetIDType(url)
+	id, idType, _ := GetIDType(url)
 
-	log.Debugf("Track type: %s", idType)
+	d.logger.Debugf("Track type: %s", idType)
 
+	items := d.downloadItems(ctx, id, idType, tracks)
+
+	if idType == ALBUM {
+		d.albumReplayGain(items)
+	}
+
+	if d.dedupPolicy == DedupReference && (idType == ALBUM || idType == PLAYLIST) {
+		if err := d.writeBatchPlaylist(id, idType, items); err != nil {
+			d.logger.Errorf("Failed to write playlist: %v", err)
+		}
+	}
+
+	return d.runHooks(HookAfterBatch, HookPayload{Type: idType, ID: id, Items: items})
+}
+
+// downloadItems downloads tracks, the items of id, in order and adds the
+// downloaded episodes of a show to its feed. Once ctx is done, the items
+// left fail with its error.
+func (d *Downloader) downloadItems(ctx context.Context, id string, idType IDType, tracks []string) []HookItem {
+	if (idType == ALBUM || idType == PLAYLIST || idType == ARTIST) && !d.isSkipAddingMetadata {
+		if err := d.PrefetchMetadata(tracks); err != nil {
+			d.logger.Warnf("Failed to prefetch metadata: %v", err)
+		}
+	}
+
+	episodes := make(map[string]string)
+	var items []HookItem
 	for _, track := range tracks {
-		switch idType {
-		case TRACK, ALBUM, PLAYLIST:
-			_, _ = d.DownloadTrack(track)
-		case SHOW, EPISODE:
-			_, _ = d.DownloadEpisode(track)
+		if err := ctx.Err(); err != nil {
+			items = append(items, HookItem{ID: track, Error: err.Error()})
+			continue
+		}
+		itemType := TRACK
+		if idType == SHOW || idType == EPISODE {
+			itemType = EPISODE
+		}
+		filePath, reused, err := d.downloadWithRetries(track, itemType)
+		if err == nil && itemType == EPISODE {
+			episodes[track] = filePath
+		}
+		item := HookItem{ID: track, Path: filePath, Reused: reused}
+		if err != nil {
+			item.Error = err.Error()
+			if err := d.runHooks(HookItemFailed, HookPayload{Type: itemType, ID: track, Error: item.Error}); err != nil {
+				d.logger.Warnf("%v", err)
+			}
+		}
+		items = append(items, item)
+	}
+
+	if idType == SHOW && len(episodes) > 0 {
+		if err := d.updateShowFeed(id, episodes); err != nil {
+			d.logger.Errorf("Failed to update podcast feed: %v", err)
+		}
+	}
+	return items
+}
+
+// albumReplayGain writes album ReplayGain tags to the files of items, the
+// tracks of an album, if none of them failed. Reused items count towards
+// the album loudness, but are not tagged: their files are shared with, or
+// belong to, another album.
+func (d *Downloader) albumReplayGain(items []HookItem) {
+	if !d.isReplayGain || !hasFFmpeg {
+		return
+	}
+	var albumFiles, tagFiles []string
+	for _, item := range items {
+		if item.Error != "" {
+			continue
+		}
+		albumFiles = append(albumFiles, item.Path)
+		if !item.Reused {
+			tagFiles = append(tagFiles, item.Path)
+		}
+	}
+	if len(albumFiles) != len(items) {
+		d.logger.Warnf("Skip album ReplayGain tags, %d of %d track(s) failed", len(items)-len(albumFiles), len(items))
+		return
+	}
+	if len(tagFiles) == 0 {
+		return
+	}
+
+	if err := d.addAlbumReplayGain(albumFiles, tagFiles); err != nil {
+		d.logger.Errorf("Failed to add album ReplayGain tags: %v", err)
+	}
+	for _, file := range tagFiles {
+		if err := d.addToManifest(file, ManifestEntry{}); err != nil {
+			d.logger.Warnf("Failed to update [%s] in the manifest: %v", file, err)
 		}
 	}
-	return nil
 }
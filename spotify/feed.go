@@ -0,0 +1,301 @@
+package spotify
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const itunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+type rssFeed struct {
+	XMLName     xml.Name   `xml:"rss"`
+	Version     string     `xml:"version,attr"`
+	ITunesXMLNS string     `xml:"xmlns:itunes,attr"`
+	Channel     rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title          string       `xml:"title"`
+	Link           string       `xml:"link"`
+	Description    string       `xml:"description"`
+	Language       string       `xml:"language,omitempty"`
+	LastBuildDate  string       `xml:"lastBuildDate"`
+	Image          *rssImage    `xml:"image,omitempty"`
+	ITunesAuthor   string       `xml:"itunes:author,omitempty"`
+	ITunesSummary  string       `xml:"itunes:summary,omitempty"`
+	ITunesExplicit string       `xml:"itunes:explicit"`
+	ITunesImage    *itunesImage `xml:"itunes:image,omitempty"`
+	Items          []rssItem    `xml:"item"`
+}
+
+type rssImage struct {
+	URL   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title          string       `xml:"title"`
+	Description    string       `xml:"description"`
+	PubDate        string       `xml:"pubDate,omitempty"`
+	GUID           rssGUID      `xml:"guid"`
+	Enclosure      rssEnclosure `xml:"enclosure"`
+	ITunesDuration string       `xml:"itunes:duration,omitempty"`
+	ITunesExplicit string       `xml:"itunes:explicit,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// existingFeed mirrors rssFeed for decoding, since the prefixed itunes
+// tags used for encoding do not match namespace-resolved names on read.
+type existingFeed struct {
+	Channel struct {
+		Items []struct {
+			Title          string       `xml:"title"`
+			Description    string       `xml:"description"`
+			PubDate        string       `xml:"pubDate"`
+			GUID           rssGUID      `xml:"guid"`
+			Enclosure      rssEnclosure `xml:"enclosure"`
+			ITunesDuration string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			ITunesExplicit string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// updateShowFeed writes the RSS feed for a downloaded show next to its
+// episodes, merging the newly downloaded episodes into the items written by
+// previous runs.
+func (d *Downloader) updateShowFeed(showID string, downloaded map[string]string) error {
+	show, err := d.queryShowAPI(showID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch show data: %w", err)
+	}
+
+	feedDir := d.showFolder(downloaded)
+	feedPath := filepath.Join(feedDir, cleanFilename(show.Name, d.filenamePolicy)+".xml")
+	items, err := readFeedItems(feedPath)
+	if err != nil {
+		d.logger.Warnf("Failed to read existing feed [%s]: %v, rebuilding it", feedPath, err)
+		items = map[string]rssItem{}
+	}
+
+	// Page through the episodes only until every downloaded one is found
+	pending := maps.Clone(downloaded)
+	for offset := 0; len(pending) > 0; offset += 50 {
+		episodes, err := d.queryShowTracksAPI(showID, offset)
+		if err != nil {
+			return fmt.Errorf("failed to fetch show episodes: %w", err)
+		}
+		for _, episode := range episodes.Items {
+			filePath, ok := pending[episode.Id]
+			if !ok {
+				continue
+			}
+			delete(pending, episode.Id)
+			item, err := d.newFeedItem(filePath, feedDir)
+			if err != nil {
+				d.logger.Warnf("Skip episode [%s] in feed: %v", episode.Name, err)
+				continue
+			}
+			item.Title = episode.Name
+			item.Description = episode.Description
+			if date, err := time.Parse("2006-01-02", episode.ReleaseDate); err == nil {
+				item.PubDate = date.Format(time.RFC1123Z)
+			}
+			item.GUID = rssGUID{IsPermaLink: "false", Value: fmt.Sprintf("spotify:episode:%s", episode.Id)}
+			item.ITunesDuration = formatFeedDuration(episode.DurationMS)
+			item.ITunesExplicit = formatExplicit(episode.Explicit)
+			items[item.GUID.Value] = item
+		}
+		if len(episodes.Items) < 50 {
+			break
+		}
+	}
+
+	feed := rssFeed{
+		Version:     "2.0",
+		ITunesXMLNS: itunesNamespace,
+		Channel: rssChannel{
+			Title:          show.Name,
+			Link:           show.ExternalUrls.Spotify,
+			Description:    show.Description,
+			LastBuildDate:  time.Now().Format(time.RFC1123Z),
+			ITunesAuthor:   show.Publisher,
+			ITunesSummary:  show.Description,
+			ITunesExplicit: formatExplicit(show.Explicit),
+		},
+	}
+	if len(show.Languages) > 0 {
+		feed.Channel.Language = show.Languages[0]
+	}
+	if len(show.Images) > 0 {
+		sort.Slice(show.Images, func(i, j int) bool {
+			return show.Images[i].Width*show.Images[i].Height > show.Images[j].Width*show.Images[j].Height
+		})
+		feed.Channel.Image = &rssImage{URL: show.Images[0].URL, Title: show.Name, Link: show.ExternalUrls.Spotify}
+		feed.Channel.ITunesImage = &itunesImage{Href: show.Images[0].URL}
+	}
+
+	for _, item := range items {
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	sort.Slice(feed.Channel.Items, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC1123Z, feed.Channel.Items[i].PubDate)
+		tj, _ := time.Parse(time.RFC1123Z, feed.Channel.Items[j].PubDate)
+		return ti.After(tj)
+	})
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode feed: %w", err)
+	}
+	data = append([]byte(xml.Header), data...)
+	if err := fileutil.WriteFile(feedPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write feed: %w", err)
+	}
+
+	d.logger.Infof("Updated podcast feed [%s] with %d episode(s)", feedPath, len(feed.Channel.Items))
+	if err := d.addToManifest(feedPath, ManifestEntry{ID: showID}); err != nil {
+		d.logger.Warnf("Failed to add [%s] to the manifest: %v", feedPath, err)
+	}
+	return nil
+}
+
+func readFeedItems(feedPath string) (map[string]rssItem, error) {
+	items := map[string]rssItem{}
+	data, err := os.ReadFile(feedPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var feed existingFeed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		return nil, err
+	}
+	for _, item := range feed.Channel.Items {
+		if item.GUID.Value == "" {
+			continue
+		}
+		items[item.GUID.Value] = rssItem{
+			Title:          item.Title,
+			Description:    item.Description,
+			PubDate:        item.PubDate,
+			GUID:           item.GUID,
+			Enclosure:      item.Enclosure,
+			ITunesDuration: item.ITunesDuration,
+			ITunesExplicit: item.ITunesExplicit,
+		}
+	}
+	return items, nil
+}
+
+// showFolder returns the folder holding the downloaded episodes of a show,
+// the deepest folder below the output folder that contains all of them.
+func (d *Downloader) showFolder(downloaded map[string]string) string {
+	var dir string
+	for _, filePath := range downloaded {
+		if dir == "" {
+			dir = filepath.Dir(filePath)
+		}
+		for !isWithin(dir, filePath) && dir != filepath.Dir(dir) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	if dir == "" || !isWithin(d.outputFolder, dir) {
+		return d.outputFolder
+	}
+	return dir
+}
+
+// isWithin reports whether path is dir or below it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// newFeedItem returns the feed item of the episode at filePath, linked
+// relative to feedDir, the folder of the feed, or below the feed base URL,
+// which is the URL of the output folder.
+func (d *Downloader) newFeedItem(filePath, feedDir string) (rssItem, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return rssItem{}, err
+	}
+	base := feedDir
+	if d.feedBaseURL != "" {
+		base = d.outputFolder
+	}
+	relPath, err := filepath.Rel(base, filePath)
+	if err != nil {
+		return rssItem{}, err
+	}
+
+	segments := strings.Split(filepath.ToSlash(relPath), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	enclosureURL := strings.Join(segments, "/")
+	if d.feedBaseURL != "" {
+		enclosureURL = strings.TrimSuffix(d.feedBaseURL, "/") + "/" + enclosureURL
+	}
+
+	return rssItem{
+		Enclosure: rssEnclosure{
+			URL:    enclosureURL,
+			Length: info.Size(),
+			Type:   audioMimeType(filePath),
+		},
+	}, nil
+}
+
+func audioMimeType(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".m4a":
+		return "audio/mp4"
+	case ".ogg", ".opus":
+		return "audio/ogg"
+	case ".flac":
+		return "audio/flac"
+	case ".mp3":
+		return "audio/mpeg"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func formatFeedDuration(durationMS int) string {
+	seconds := durationMS / 1000
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+func formatExplicit(explicit bool) string {
+	if explicit {
+		return "true"
+	}
+	return "false"
+}
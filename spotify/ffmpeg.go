@@ -9,16 +9,20 @@ import (
 	"path/filepath"
 )
 
-var hasFFmpeg bool
+var hasFFmpeg, hasFFprobe bool
 
 func init() {
 	_, err := exec.LookPath("ffmpeg")
 	if err == nil {
 		hasFFmpeg = true
 	}
+	_, err = exec.LookPath("ffprobe")
+	if err == nil {
+		hasFFprobe = true
+	}
 }
 
-func encodeMetadata(inputFile, coverFilePath string, metadata map[string]string) error {
+func (d *Downloader) encodeMetadata(inputFile, coverFilePath string, metadata map[string]string) error {
 	tempFile := inputFile + ".tmp" + filepath.Ext(inputFile)
 
 	var mdArg []string
@@ -34,7 +38,9 @@ func encodeMetadata(inputFile, coverFilePath string, metadata map[string]string)
 		"metadata": mdArg,
 	}
 
-	if coverFilePath != "" {
+	// The ogg muxer, also used for opus, cannot carry an attached picture
+	// stream
+	if ext := filepath.Ext(inputFile); coverFilePath != "" && ext != ".ogg" && ext != ".opus" {
 		if _, err := os.Stat(coverFilePath); !os.IsNotExist(err) {
 			input = append(input, []*ffmpeg.Stream{ffmpeg.Input(coverFilePath)}...)
 			args["disposition:v:0"] = "attached_pic"
@@ -44,7 +50,7 @@ func encodeMetadata(inputFile, coverFilePath string, metadata map[string]string)
 	ff := ffmpeg.Output(input, tempFile, args).
 		OverWriteOutput().Silent(true)
 
-	if log.GetLevel() == log.LevelDebug {
+	if d.logger.Enabled(log.LevelDebug) {
 		ff.Silent(false).WithErrorOutput(os.Stderr)
 	}
 
@@ -62,52 +68,3 @@ func encodeMetadata(inputFile, coverFilePath string, metadata map[string]string)
 	}
 	return nil
 }
-
-func (d *Downloader) convertMp3(inputFile string, outputFile string) (err error) {
-	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
-		return fmt.Errorf(`input file [%s] not exists`, inputFile)
-	}
-
-	_, err = exec.LookPath("ffmpeg")
-	if err != nil {
-		log.Warnln("ffmpeg not found, skip converting format")
-		return
-	}
-
-	log.Debugf("Converting [%s] to [%s]", inputFile, outputFile)
-
-	bitrate := ""
-	switch d.quality {
-	case Quality96Vorbis:
-		bitrate = "96"
-	case Quality128MP4, Quality128MP4Dual:
-		bitrate = "128"
-	case Quality160Vorbis:
-		bitrate = "160"
-	case Quality256MP4, Quality256MP4Dual:
-		bitrate = "256"
-	case Quality320Vorbis:
-		bitrate = "320"
-	}
-	log.Debugf("Set convertor bitrate: %sk", bitrate)
-
-	ff := ffmpeg.Input(inputFile).
-		Output(outputFile, ffmpeg.KwArgs{
-			"format":        "mp3",
-			"audio_bitrate": bitrate + "k",
-			// "acodec": 	"libmp3lame",
-		}).
-		OverWriteOutput().Silent(true)
-
-	if log.GetLevel() == log.LevelDebug {
-		ff.Silent(false).WithErrorOutput(os.Stderr)
-	}
-
-	err = ff.Run()
-	if err != nil {
-		return fmt.Errorf("error while converting to mp3: %v", err)
-	}
-	log.Debugln("Convert successfully")
-
-	return
-}
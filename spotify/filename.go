@@ -0,0 +1,195 @@
+package spotify
+
+import (
+	"fmt"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FilenamePolicy selects which characters and names are allowed in the names
+// of downloaded files and folders.
+type FilenamePolicy string
+
+const (
+	// FilenamePOSIX only removes slashes and control characters.
+	FilenamePOSIX FilenamePolicy = "posix"
+	// FilenameWindows also removes characters and names Windows and SMB
+	// shares reject, such as ":", "?" and "CON".
+	FilenameWindows FilenamePolicy = "windows"
+	// FilenameASCII follows FilenameWindows and transliterates the rest to
+	// ASCII, replacing characters without a transliteration with "_".
+	FilenameASCII FilenamePolicy = "ascii"
+)
+
+// maxFilenameBytes leaves room below the 255-byte limit of most file systems
+// for the extension and the suffixes of temporary files.
+const maxFilenameBytes = 240
+
+var (
+	posixIllegalChars   = regexp.MustCompile(`[/\x00-\x1F\x7F]`)
+	windowsIllegalChars = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1F\x7F]`)
+	windowsReservedName = regexp.MustCompile(`(?i)^(CON|PRN|AUX|NUL|COM[0-9¹²³]|LPT[0-9¹²³])(\..*)?$`)
+)
+
+// asciiReplacements transliterates letters that do not decompose into an
+// ASCII letter and combining marks.
+var asciiReplacements = strings.NewReplacer(
+	"ß", "ss", "Æ", "AE", "æ", "ae", "Œ", "OE", "œ", "oe", "Ø", "O", "ø", "o",
+	"Ł", "L", "ł", "l", "Đ", "D", "đ", "d", "Ð", "D", "ð", "d", "Þ", "Th", "þ", "th",
+	"ı", "i", "‘", "'", "’", "'", "“", "\"", "”", "\"", "–", "-", "—", "-", "…", "...",
+)
+
+// DefaultFilenamePolicy is FilenameWindows on Windows and FilenamePOSIX
+// elsewhere.
+func DefaultFilenamePolicy() FilenamePolicy {
+	if runtime.GOOS == "windows" {
+		return FilenameWindows
+	}
+	return FilenamePOSIX
+}
+
+// ParseFilenamePolicy returns the policy named name, or the default policy
+// if name is empty.
+func ParseFilenamePolicy(name string) (FilenamePolicy, error) {
+	switch policy := FilenamePolicy(name); policy {
+	case "":
+		return DefaultFilenamePolicy(), nil
+	case FilenamePOSIX, FilenameWindows, FilenameASCII:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown filename policy %q, expected %s, %s or %s", name, FilenamePOSIX, FilenameWindows, FilenameASCII)
+	}
+}
+
+// cleanFilename turns filename into a single path element allowed by policy.
+// It is normalised to NFC, so that names look the same whatever form the
+// metadata used, and truncated to maxFilenameBytes without splitting a
+// character.
+func cleanFilename(filename string, policy FilenamePolicy) string {
+	cleaned := norm.NFC.String(filename)
+
+	switch policy {
+	case FilenameASCII:
+		cleaned = windowsIllegalChars.ReplaceAllString(toASCII(cleaned), "")
+	case FilenameWindows:
+		cleaned = windowsIllegalChars.ReplaceAllString(cleaned, "")
+	default:
+		cleaned = posixIllegalChars.ReplaceAllString(cleaned, "")
+	}
+	cleaned = strings.TrimSpace(cleaned)
+
+	cleaned = truncateBytes(cleaned, maxFilenameBytes)
+	if policy != FilenamePOSIX {
+		// Windows drops trailing dots and spaces, so that "a." and "a"
+		// would name the same file
+		cleaned = strings.TrimRight(cleaned, ". ")
+		if windowsReservedName.MatchString(cleaned) {
+			cleaned = "_" + cleaned
+		}
+	}
+
+	if cleaned == "." || cleaned == ".." || cleaned == "" {
+		return "null"
+	}
+	return cleaned
+}
+
+// toASCII transliterates s to ASCII by dropping accents and replacing
+// common letters, and replaces the characters left with "_".
+func toASCII(s string) string {
+	s = asciiReplacements.Replace(s)
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if stripped, _, err := transform.String(t, s); err == nil {
+		s = stripped
+	}
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// truncateBytes shortens s to at most n bytes, cutting before the character
+// that would be split.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// formatOutputPath fills the {placeholders} of an output template. Each
+// slash-separated part of the template becomes one cleaned path element.
+func formatOutputPath(template string, fields map[string]string, policy FilenamePolicy) string {
+	segments := strings.Split(filepath.ToSlash(template), "/")
+	for i, segment := range segments {
+		for key, value := range fields {
+			segment = strings.ReplaceAll(segment, "{"+key+"}", value)
+		}
+		segments[i] = cleanFilename(segment, policy)
+	}
+	return filepath.Join(segments...)
+}
+
+// SetFilenamePolicy selects the names allowed for downloaded files and
+// folders, see ParseFilenamePolicy.
+func (d *Downloader) SetFilenamePolicy(name string) error {
+	policy, err := ParseFilenamePolicy(name)
+	if err != nil {
+		return err
+	}
+	d.filenamePolicy = policy
+	return nil
+}
+
+// claimFilename returns fileName, a path below the output folder without
+// extension, for the item ID. If another item already uses the name, either
+// earlier in this run or in the manifest of its folder, a " (2)", " (3)"...
+// suffix is added instead. Names are compared ignoring case, as on Windows
+// and macOS file systems.
+func (d *Downloader) claimFilename(fileName string, ID string) string {
+	d.filenamesMu.Lock()
+	defer d.filenamesMu.Unlock()
+
+	name := fileName
+	for n := 2; ; n++ {
+		key := strings.ToLower(name)
+		owner, ok := d.filenames[key]
+		if !ok {
+			owner = d.manifestOwner(name)
+		}
+		if owner == "" || owner == ID {
+			d.filenames[key] = ID
+			return name
+		}
+		name = fmt.Sprintf("%s (%d)", fileName, n)
+	}
+}
+
+// manifestOwner returns the ID of the item downloaded as fileName, with any
+// extension, according to the manifest of its folder.
+func (d *Downloader) manifestOwner(fileName string) string {
+	manifest, err := ReadManifest(filepath.Join(d.outputFolder, filepath.Dir(fileName)))
+	if err != nil {
+		return ""
+	}
+	base := filepath.Base(fileName)
+	for name, entry := range manifest.Files {
+		// Only downloaded items have a file ID, unlike covers and feeds
+		if entry.FileID != "" && strings.EqualFold(strings.TrimSuffix(name, filepath.Ext(name)), base) {
+			return entry.ID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package spotify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTruncateBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{name: "short enough", s: "abc", n: 5, want: "abc"},
+		{name: "exact length", s: "abc", n: 3, want: "abc"},
+		{name: "ascii", s: "abcdef", n: 3, want: "abc"},
+		{name: "inside a two-byte character", s: "héllo", n: 2, want: "h"},
+		{name: "after a two-byte character", s: "héllo", n: 3, want: "hé"},
+		{name: "first byte of a three-byte character", s: "日本", n: 4, want: "日"},
+		{name: "last byte of a three-byte character", s: "日本", n: 5, want: "日"},
+		{name: "inside the first character", s: "😀x", n: 3, want: ""},
+		{name: "zero", s: "abc", n: 0, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateBytes(tt.s, tt.n); got != tt.want {
+				t.Errorf("truncateBytes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToASCII(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{s: "Beyoncé", want: "Beyonce"},
+		{s: "Straße", want: "Strasse"},
+		{s: "Ærøskøbing", want: "AEroskobing"},
+		{s: "Łódź", want: "Lodz"},
+		{s: "“Quote” – dash…", want: `"Quote" - dash...`},
+		{s: "日本", want: "__"},
+		{s: "plain", want: "plain"},
+	}
+	for _, tt := range tests {
+		if got := toASCII(tt.s); got != tt.want {
+			t.Errorf("toASCII(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCleanFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		policy   FilenamePolicy
+		want     string
+	}{
+		{name: "nfc", filename: "Beyonce\u0301", policy: FilenamePOSIX, want: "Beyonc\u00e9"},
+		{name: "posix removes slashes", filename: "AC/DC: Live?", policy: FilenamePOSIX, want: "ACDC: Live?"},
+		{name: "posix removes control characters", filename: "a\tb\x7f", policy: FilenamePOSIX, want: "ab"},
+		{name: "windows removes illegal characters", filename: `AC/DC: "Live" <at> *Donington*?`, policy: FilenameWindows, want: "ACDC Live at Donington"},
+		{name: "windows reserved name", filename: "CON", policy: FilenameWindows, want: "_CON"},
+		{name: "windows reserved name with extension", filename: "CON.txt", policy: FilenameWindows, want: "_CON.txt"},
+		{name: "windows reserved name ignores case", filename: "con.tar.gz", policy: FilenameWindows, want: "_con.tar.gz"},
+		{name: "windows reserved port", filename: "COM1", policy: FilenameWindows, want: "_COM1"},
+		{name: "windows reserved superscript port", filename: "LPT¹", policy: FilenameWindows, want: "_LPT¹"},
+		{name: "windows name starting like a reserved one", filename: "CONSOLE", policy: FilenameWindows, want: "CONSOLE"},
+		{name: "posix keeps reserved names", filename: "CON", policy: FilenamePOSIX, want: "CON"},
+		{name: "windows trailing dots and spaces", filename: "Vol. 1. ", policy: FilenameWindows, want: "Vol. 1"},
+		{name: "posix keeps trailing dots", filename: "Vol. 1.", policy: FilenamePOSIX, want: "Vol. 1."},
+		{name: "ascii", filename: "Beyoncé: Live?", policy: FilenameASCII, want: "Beyonce Live"},
+		{name: "ascii reserved name", filename: "Ñul", policy: FilenameASCII, want: "_Nul"},
+		{name: "dot dot", filename: "..", policy: FilenamePOSIX, want: "null"},
+		{name: "only slashes", filename: "///", policy: FilenamePOSIX, want: "null"},
+		{name: "empty", filename: "", policy: FilenameWindows, want: "null"},
+		{name: "only dots on windows", filename: "...", policy: FilenameWindows, want: "null"},
+		{
+			name:     "long three-byte characters",
+			filename: strings.Repeat("日", 100),
+			policy:   FilenamePOSIX,
+			want:     strings.Repeat("日", maxFilenameBytes/3),
+		},
+		{
+			name:     "long name cut before a split character",
+			filename: "a" + strings.Repeat("é", 120),
+			policy:   FilenamePOSIX,
+			want:     "a" + strings.Repeat("é", 119),
+		},
+		{
+			name:     "long name composed before truncation",
+			filename: strings.Repeat("e\u0301", 100),
+			policy:   FilenamePOSIX,
+			want:     strings.Repeat("\u00e9", 100),
+		},
+		{
+			name:     "windows trailing dot after truncation",
+			filename: strings.Repeat("a", maxFilenameBytes-1) + ". b",
+			policy:   FilenameWindows,
+			want:     strings.Repeat("a", maxFilenameBytes-1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cleanFilename(tt.filename, tt.policy)
+			if got != tt.want {
+				t.Errorf("cleanFilename(%q, %s) = %q, want %q", tt.filename, tt.policy, got, tt.want)
+			}
+			if len(got) > maxFilenameBytes {
+				t.Errorf("cleanFilename(%q, %s) is %d bytes, want at most %d", tt.filename, tt.policy, len(got), maxFilenameBytes)
+			}
+		})
+	}
+}
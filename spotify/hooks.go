@@ -0,0 +1,196 @@
+package spotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// HookAfterDownload runs once a file is downloaded, decrypted,
+	// converted and verified, before it is tagged.
+	HookAfterDownload = "after-download"
+	// HookAfterTagging runs once a file is complete, after tagging.
+	HookAfterTagging = "after-tagging"
+	// HookItemFailed runs once an item failed, with the error in the
+	// payload.
+	HookItemFailed = "item-failed"
+	// HookAfterBatch runs once every item of a URL has been processed.
+	HookAfterBatch = "after-batch"
+)
+
+// DefaultHookTimeout is how long a hook may run if its Timeout is not set.
+const DefaultHookTimeout = time.Minute
+
+// hookWaitDelay is how long a killed hook command may hold its output open,
+// e.g. through a child process it started, before it is abandoned.
+const hookWaitDelay = 5 * time.Second
+
+// HookFunc is a hook implemented in Go. ctx is cancelled when the hook
+// times out.
+type HookFunc func(ctx context.Context, payload HookPayload) error
+
+// Hook runs a command or a Go function at a stage of processing.
+type Hook struct {
+	// Stage is one of HookAfterDownload, HookAfterTagging, HookItemFailed
+	// and HookAfterBatch.
+	Stage string
+	// Command is run with the payload as JSON on stdin, unless Func is set.
+	Command []string
+	Func    HookFunc
+	Timeout time.Duration
+	// FailItem marks the item, or the batch, failed when the hook fails.
+	// Failures are only logged otherwise.
+	FailItem bool
+}
+
+// HookPayload describes the item, or batch of items, a hook runs for.
+type HookPayload struct {
+	Stage  string `json:"stage"`
+	Type   IDType `json:"type"`
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	Album  string `json:"album,omitempty"`
+	Track  int    `json:"track,omitempty"`
+	Disc   int    `json:"disc,omitempty"`
+	// Path is the downloaded file.
+	Path string `json:"path,omitempty"`
+	// Error is why the item failed.
+	Error string `json:"error,omitempty"`
+	// Items are the results of a batch.
+	Items []HookItem `json:"items,omitempty"`
+}
+
+// HookItem is the result of one item of a batch.
+type HookItem struct {
+	ID    string `json:"id"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Reused is true if the file was reused from the library instead of
+	// downloaded.
+	Reused bool `json:"reused,omitempty"`
+}
+
+// ParseHook parses a hook given as the stage, optional comma-separated
+// options and the command, separated by a colon, e.g.
+// "after-tagging:/usr/local/bin/rescan" or
+// "after-download,timeout=5m,fail:rsync-to-nas". The options are
+// timeout=<duration>, fail (set FailItem) and warn.
+func ParseHook(spec string) (Hook, error) {
+	var hook Hook
+	head, command, ok := strings.Cut(spec, ":")
+	if !ok {
+		return hook, fmt.Errorf("invalid hook %q, expected stage:command", spec)
+	}
+	options := strings.Split(head, ",")
+	hook.Stage = strings.TrimSpace(options[0])
+	for _, option := range options[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case "timeout":
+			timeout, err := time.ParseDuration(value)
+			if err != nil {
+				return hook, fmt.Errorf("invalid hook timeout %q", value)
+			}
+			hook.Timeout = timeout
+		case "fail":
+			hook.FailItem = true
+		case "warn":
+			hook.FailItem = false
+		default:
+			return hook, fmt.Errorf("unknown hook option %q, expected timeout, fail or warn", key)
+		}
+	}
+	hook.Command = strings.Fields(command)
+	return hook, hook.validate()
+}
+
+func (h Hook) validate() error {
+	switch h.Stage {
+	case HookAfterDownload, HookAfterTagging, HookItemFailed, HookAfterBatch:
+	default:
+		return fmt.Errorf("unknown hook stage %q, expected %s, %s, %s or %s", h.Stage, HookAfterDownload, HookAfterTagging, HookItemFailed, HookAfterBatch)
+	}
+	switch {
+	case h.Func == nil && len(h.Command) == 0:
+		return errors.New("hook has neither a command nor a function")
+	case h.Timeout < 0:
+		return errors.New("hook timeout must not be negative")
+	}
+	return nil
+}
+
+// AddHook adds a hook run at h.Stage, after the hooks added before it.
+func (d *Downloader) AddHook(h Hook) error {
+	if err := h.validate(); err != nil {
+		return err
+	}
+	d.hooks = append(d.hooks, h)
+	return nil
+}
+
+// runHooks runs the hooks of stage in order. It returns the error of the
+// first failing hook with FailItem set, and logs the others.
+func (d *Downloader) runHooks(stage string, payload HookPayload) error {
+	payload.Stage = stage
+	for _, h := range d.hooks {
+		if h.Stage != stage {
+			continue
+		}
+		err := d.runHook(h, payload)
+		switch {
+		case err == nil:
+		case h.FailItem:
+			return fmt.Errorf("%s hook failed: %w", stage, err)
+		default:
+			d.logger.Warnf("%s hook failed: %v", stage, err)
+		}
+	}
+	return nil
+}
+
+func (d *Downloader) runHook(h Hook, payload HookPayload) error {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if h.Func != nil {
+		return h.Func(ctx, payload)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	d.logger.Debugf("Running %s hook: %s", h.Stage, strings.Join(h.Command, " "))
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.WaitDelay = hookWaitDelay
+	cmd.Env = append(os.Environ(),
+		"SPDL_HOOK_STAGE="+payload.Stage,
+		"SPDL_HOOK_ID="+payload.ID,
+		"SPDL_HOOK_PATH="+payload.Path,
+		"SPDL_HOOK_ERROR="+payload.Error,
+	)
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		d.logger.Debugf("Output of %s hook: %s", h.Stage, strings.TrimSpace(string(output)))
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s", h.Command[0], timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", h.Command[0], err)
+	}
+	return nil
+}
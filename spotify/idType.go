@@ -14,6 +14,7 @@ const (
 	PLAYLIST IDType = "playlist"
 	SHOW     IDType = "show"
 	EPISODE  IDType = "episode"
+	ARTIST   IDType = "artist"
 )
 
 func GetIDType(urlID string) (string, IDType, error) {
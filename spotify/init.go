@@ -3,7 +3,6 @@ package spotify
 import (
 	"encoding/json"
 	"fmt"
-	log "github.com/XiaoMengXinX/sp-dl-go/logger"
 	"io"
 	"net/http"
 	"os"
@@ -13,29 +12,29 @@ import (
 
 var cdmData []byte
 
-func readCDMs() []string {
+func (d *Downloader) readCDMs() []string {
 	cdms, err := filepath.Glob(filepath.Join("cdm", "*.wvd"))
 	if err != nil || len(cdms) == 0 {
-		log.Fatal(`No CDMs found in "./cdm" folder`)
+		d.logger.Fatal(`No CDMs found in "./cdm" folder`)
 	}
 	cdmData, err = os.ReadFile(cdms[0])
 	if err != nil {
-		log.Fatal("Failed to read CDM file")
+		d.logger.Fatal("Failed to read CDM file")
 	}
 	return cdms
 }
 
-func requestClientBases() []string {
+func (d *Downloader) requestClientBases() []string {
 	resp, err := http.Get("https://apresolve.spotify.com?type=spclient")
 	if err != nil {
-		log.Errorf("Unable to request client bases: %v", err)
+		d.logger.Errorf("Unable to request client bases: %v", err)
 		return nil
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		log.Errorf("Unable to request client bases (%d): %s", resp.StatusCode, body)
+		d.logger.Errorf("Unable to request client bases (%d): %s", resp.StatusCode, body)
 		return nil
 	}
 
@@ -44,13 +43,13 @@ func requestClientBases() []string {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		log.Errorf("Error decoding client bases response: %v", err)
+		d.logger.Errorf("Error decoding client bases response: %v", err)
 		return nil
 	}
 
 	var formattedEndpoints []string
 	for _, endpoint := range response.SpClient {
-		formatted := formatEndpoint(endpoint)
+		formatted := d.formatEndpoint(endpoint)
 		if formatted != "" {
 			formattedEndpoints = append(formattedEndpoints, formatted)
 		}
@@ -59,18 +58,18 @@ func requestClientBases() []string {
 	return formattedEndpoints
 }
 
-func buildLicenseURL(clientBases []string) string {
+func (d *Downloader) buildLicenseURL(clientBases []string) string {
 	if len(clientBases) == 0 {
-		log.Warn("No client bases available to build license URL")
+		d.logger.Warn("No client bases available to build license URL")
 		return ""
 	}
 	return fmt.Sprintf("%s/widevine-license/v1/audio/license", clientBases[0])
 }
 
-func formatEndpoint(endpoint string) string {
+func (d *Downloader) formatEndpoint(endpoint string) string {
 	parts := strings.Split(endpoint, ":")
 	if len(parts) != 2 {
-		log.Warnf("Invalid endpoint format: %s", endpoint)
+		d.logger.Warnf("Invalid endpoint format: %s", endpoint)
 		return ""
 	}
 	domain, port := parts[0], parts[1]
@@ -81,7 +80,7 @@ func formatEndpoint(endpoint string) string {
 	case "443":
 		return fmt.Sprintf("https://%s", domain)
 	default:
-		log.Warnf("Unknown port: %s", port)
+		d.logger.Warnf("Unknown port: %s", port)
 		return ""
 	}
 }
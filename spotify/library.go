@@ -0,0 +1,286 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Dedup policies select what is done with an item already in the library
+// index.
+const (
+	// DedupOff downloads every item again.
+	DedupOff = "off"
+	// DedupHardlink hardlinks the file in the library to the new path.
+	DedupHardlink = "hardlink"
+	// DedupSymlink symlinks the new path to the file in the library.
+	DedupSymlink = "symlink"
+	// DedupReference writes nothing for the item, and lists the file in the
+	// library in the m3u8 playlist written for albums and playlists.
+	DedupReference = "reference"
+)
+
+// DefaultLibraryIndexName is the name of the library index in the output
+// folder, unless set with SetLibraryIndex.
+const DefaultLibraryIndexName = "library.json"
+
+// libraryVersion is the format version of library index files.
+const libraryVersion = 1
+
+// LibraryEntry records a downloaded recording in the library index.
+type LibraryEntry struct {
+	ID      string    `json:"id"`
+	Type    IDType    `json:"type"`
+	ISRC    string    `json:"isrc,omitempty"`
+	FileID  string    `json:"file_id,omitempty"`
+	Quality string    `json:"quality"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+}
+
+type libraryIndex struct {
+	Version int `json:"version"`
+	// Items are keyed by track or episode ID.
+	Items map[string]LibraryEntry `json:"items"`
+}
+
+// SetDedupPolicy selects what is done with items found in the library
+// index by track ID, ISRC or file ID: one of DedupOff, DedupHardlink,
+// DedupSymlink and DedupReference. A file is only reused if it has the
+// extension a new download would get, at equal or better quality.
+func (d *Downloader) SetDedupPolicy(policy string) error {
+	switch policy {
+	case DedupOff, DedupHardlink, DedupSymlink, DedupReference:
+	default:
+		return fmt.Errorf("unknown dedup policy %q, expected %s, %s, %s or %s", policy, DedupOff, DedupHardlink, DedupSymlink, DedupReference)
+	}
+	d.dedupPolicy = policy
+	return nil
+}
+
+// SetLibraryIndex sets the library index file shared by the output folders
+// of several runs. It is DefaultLibraryIndexName in the output folder if
+// empty.
+func (d *Downloader) SetLibraryIndex(path string) *Downloader {
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+	d.libraryIndexPath = path
+	return d
+}
+
+func (d *Downloader) libraryIndexFile() string {
+	if d.libraryIndexPath != "" {
+		return d.libraryIndexPath
+	}
+	return filepath.Join(d.outputFolder, DefaultLibraryIndexName)
+}
+
+func readLibraryIndex(path string) (libraryIndex, error) {
+	index := libraryIndex{Items: make(map[string]LibraryEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, fmt.Errorf("unable to parse library index [%s]: %w", path, err)
+	}
+	if index.Version > libraryVersion {
+		return index, fmt.Errorf("library index [%s] has version %d, newer than the supported version %d", path, index.Version, libraryVersion)
+	}
+	if index.Items == nil {
+		index.Items = make(map[string]LibraryEntry)
+	}
+	return index, nil
+}
+
+// addToLibrary records entry in the library index, unless the index has a
+// better file for the same ID that still exists.
+func (d *Downloader) addToLibrary(entry LibraryEntry) error {
+	if d.dedupPolicy == DedupOff {
+		return nil
+	}
+	if path, err := filepath.Abs(entry.Path); err == nil {
+		entry.Path = path
+	}
+	entry.Time = time.Now().UTC()
+
+	path := d.libraryIndexFile()
+	unlock, err := fileutil.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index, err := readLibraryIndex(path)
+	if err != nil {
+		return err
+	}
+	if old, ok := index.Items[entry.ID]; ok && old.Path != entry.Path &&
+		qualityBitrate(old.Quality) > qualityBitrate(entry.Quality) && fileExists(old.Path) {
+		return nil
+	}
+	index.Version = libraryVersion
+	index.Items[entry.ID] = entry
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(path, data, 0644)
+}
+
+// findInLibrary returns the entry of the library index for the track ID,
+// or else for the same recording by ISRC or file ID, whose file still
+// exists with extension ext at equal or better quality than a new download.
+// Of several recordings, the one of the best quality, then the first by
+// path, is returned.
+func (d *Downloader) findInLibrary(ID, isrc, fileID, ext string) (LibraryEntry, bool) {
+	index, err := readLibraryIndex(d.libraryIndexFile())
+	if err != nil {
+		d.logger.Warnf("Failed to read library index: %v", err)
+		return LibraryEntry{}, false
+	}
+
+	usable := func(entry LibraryEntry) bool {
+		return strings.EqualFold(strings.TrimPrefix(filepath.Ext(entry.Path), "."), ext) &&
+			qualityBitrate(entry.Quality) >= qualityBitrate(d.quality) &&
+			fileExists(entry.Path)
+	}
+	if entry, ok := index.Items[ID]; ok && usable(entry) {
+		return entry, true
+	}
+	var best LibraryEntry
+	found := false
+	for _, entry := range index.Items {
+		if !(isrc != "" && entry.ISRC == isrc || fileID != "" && entry.FileID == fileID) {
+			continue
+		}
+		if found {
+			bitrate, bestBitrate := qualityBitrate(entry.Quality), qualityBitrate(best.Quality)
+			if bitrate < bestBitrate || bitrate == bestBitrate && entry.Path >= best.Path {
+				continue
+			}
+		}
+		if usable(entry) {
+			best, found = entry, true
+		}
+	}
+	return best, found
+}
+
+// reuseLibraryFile makes the file src of the library available at dst the
+// way the dedup policy says, and returns where the item can be found.
+func (d *Downloader) reuseLibraryFile(src, dst string) (string, error) {
+	if d.dedupPolicy == DedupReference {
+		return src, nil
+	}
+
+	if srcInfo, err := os.Stat(src); err != nil {
+		return "", err
+	} else if dstInfo, err := os.Stat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return dst, nil
+	}
+	if err := checkDirExist(filepath.Dir(dst)); err != nil {
+		return "", err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	switch d.dedupPolicy {
+	case DedupHardlink:
+		return dst, os.Link(src, dst)
+	case DedupSymlink:
+		absSrc, err := filepath.Abs(src)
+		if err != nil {
+			return "", err
+		}
+		absDst, err := filepath.Abs(dst)
+		if err != nil {
+			return "", err
+		}
+		target, err := filepath.Rel(filepath.Dir(absDst), absSrc)
+		if err != nil {
+			target = absSrc
+		}
+		return dst, os.Symlink(target, dst)
+	}
+	return "", fmt.Errorf("unknown dedup policy %q", d.dedupPolicy)
+}
+
+// writePlaylist writes an m3u8 playlist named after name into the output
+// folder, listing files relative to it.
+func (d *Downloader) writePlaylist(name string, files []string) (string, error) {
+	absFolder, err := filepath.Abs(d.outputFolder)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, file := range files {
+		if absFile, err := filepath.Abs(file); err == nil {
+			if rel, err := filepath.Rel(absFolder, absFile); err == nil {
+				file = rel
+			}
+		}
+		b.WriteString(filepath.ToSlash(file) + "\n")
+	}
+
+	path := filepath.Join(d.outputFolder, cleanFilename(name, d.filenamePolicy)+".m3u8")
+	return path, fileutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func (m trackMetadata) isrc() string {
+	for _, id := range m.ExternalID {
+		if strings.EqualFold(id.Type, "isrc") {
+			return id.ID
+		}
+	}
+	return ""
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// writeBatchPlaylist writes the m3u8 playlist of the downloaded items of an
+// album or playlist, named after it.
+func (d *Downloader) writeBatchPlaylist(id string, idType IDType, items []HookItem) error {
+	name := id
+	switch idType {
+	case ALBUM:
+		if album, err := d.queryAlbumAPI(id); err == nil {
+			name = album.Name
+		}
+	case PLAYLIST:
+		if playlist, err := d.queryPlaylistAPI(id); err == nil {
+			name = playlist.Name
+		}
+	}
+
+	var files []string
+	for _, item := range items {
+		if item.Error == "" && item.Path != "" {
+			files = append(files, item.Path)
+		}
+	}
+	path, err := d.writePlaylist(name, files)
+	if err != nil {
+		return err
+	}
+	d.logger.Infof("Wrote playlist [%s] with %d item(s)", path, len(files))
+	if err := d.addToManifest(path, ManifestEntry{ID: id}); err != nil {
+		d.logger.Warnf("Failed to add [%s] to the manifest: %v", path, err)
+	}
+	return nil
+}
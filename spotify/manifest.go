@@ -0,0 +1,229 @@
+package spotify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ManifestName is the name of the manifest file kept in each output folder.
+const ManifestName = "manifest.json"
+
+// manifestVersion is the format version of manifest files.
+const manifestVersion = 1
+
+// Manifest lists the files of an output folder with their checksums.
+type Manifest struct {
+	Version int `json:"version"`
+	// Files are keyed by file name, relative to the folder.
+	Files map[string]ManifestEntry `json:"files"`
+}
+
+// ManifestEntry records one file of a Manifest.
+type ManifestEntry struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// ID is the track, episode or show the file was written for.
+	ID      string    `json:"id,omitempty"`
+	FileID  string    `json:"file_id,omitempty"`
+	Quality string    `json:"quality,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Problems found by ValidateManifest.
+const (
+	ManifestMissing  = "missing"
+	ManifestModified = "modified"
+	ManifestExtra    = "extra"
+)
+
+// manifestIgnored are the files of the downloader itself that are never
+// listed in manifests.
+var manifestIgnored = map[string]bool{
+	ManifestName:                      true,
+	ManifestName + ".lock":            true,
+	DefaultLibraryIndexName:           true,
+	DefaultLibraryIndexName + ".lock": true,
+	SyncStateName:                     true,
+	SyncStateName + ".lock":           true,
+	DefaultWatchStateName:             true,
+	DefaultWatchStateName + ".lock":   true,
+}
+
+// ManifestProblem is a file that does not match the manifest of its folder.
+type ManifestProblem struct {
+	Path    string
+	Problem string
+}
+
+// WriteManifests keeps a manifest with the SHA-256 checksum of every file
+// written into a folder, see ValidateManifest.
+func (d *Downloader) WriteManifests(b bool) *Downloader {
+	d.isWriteManifests = b
+	return d
+}
+
+// addToManifest records the file at path in the manifest of its folder,
+// with its current checksum and size. An entry without an ID keeps the ID,
+// file ID and quality already recorded for the file, which updates the
+// checksum of a file changed after it was added.
+func (d *Downloader) addToManifest(path string, entry ManifestEntry) error {
+	if !d.isWriteManifests {
+		return nil
+	}
+	sum, size, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	entry.SHA256 = sum
+	entry.Size = size
+	entry.Time = time.Now().UTC()
+
+	dir := filepath.Dir(path)
+	manifestPath := filepath.Join(dir, ManifestName)
+	unlock, err := fileutil.Lock(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	manifest, err := ReadManifest(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		manifest = Manifest{Files: make(map[string]ManifestEntry)}
+	} else if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	if old, ok := manifest.Files[name]; ok && entry.ID == "" {
+		entry.ID, entry.FileID, entry.Quality = old.ID, old.FileID, old.Quality
+	}
+	manifest.Version = manifestVersion
+	manifest.Files[name] = entry
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(manifestPath, data, 0644)
+}
+
+// removeFromManifest removes the file at path from the manifest of its
+// folder, if it has one.
+func (d *Downloader) removeFromManifest(path string) error {
+	dir := filepath.Dir(path)
+	manifestPath := filepath.Join(dir, ManifestName)
+	if !fileExists(manifestPath) {
+		return nil
+	}
+	unlock, err := fileutil.Lock(manifestPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	manifest, err := ReadManifest(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	if _, ok := manifest.Files[name]; !ok {
+		return nil
+	}
+	delete(manifest.Files, name)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(manifestPath, data, 0644)
+}
+
+// ReadManifest reads the manifest of the folder dir.
+func ReadManifest(dir string) (Manifest, error) {
+	var manifest Manifest
+	path := filepath.Join(dir, ManifestName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("unable to parse manifest [%s]: %w", path, err)
+	}
+	if manifest.Version > manifestVersion {
+		return manifest, fmt.Errorf("manifest [%s] has version %d, newer than the supported version %d", path, manifest.Version, manifestVersion)
+	}
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]ManifestEntry)
+	}
+	return manifest, nil
+}
+
+// ValidateManifest checks the files of the folder dir against its manifest
+// and reports files that are missing, whose size or checksum changed, and
+// files the manifest does not list. Subfolders are not checked.
+func ValidateManifest(dir string) ([]ManifestProblem, error) {
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []ManifestProblem
+	names := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		entry := manifest.Files[name]
+		sum, size, err := hashFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problems = append(problems, ManifestProblem{Path: path, Problem: ManifestMissing})
+		case err != nil:
+			return nil, err
+		case size != entry.Size || sum != entry.SHA256:
+			problems = append(problems, ManifestProblem{Path: path, Problem: ManifestModified})
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || manifestIgnored[name] {
+			continue
+		}
+		if _, ok := manifest.Files[name]; !ok {
+			problems = append(problems, ManifestProblem{Path: filepath.Join(dir, name), Problem: ManifestExtra})
+		}
+	}
+	return problems, nil
+}
+
+func hashFile(path string) (sum string, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err = io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -2,7 +2,6 @@ package spotify
 
 import (
 	"fmt"
-	log "github.com/XiaoMengXinX/sp-dl-go/logger"
 	"github.com/bogem/id3v2"
 	"net/http"
 	"os"
@@ -12,8 +11,8 @@ import (
 
 func (d *Downloader) addMetadata(trackMD trackMetadata, filePath string) (err error) {
 	trackID := SpHexToID(trackMD.GID)
-	log.Debugf("trackID: %s", trackMD.GID)
-	log.Debugf("ID: %s", SpHexToID(trackMD.GID))
+	d.logger.Debugf("trackID: %s", trackMD.GID)
+	d.logger.Debugf("ID: %s", SpHexToID(trackMD.GID))
 
 	track, err := d.queryTrackAPI(trackID)
 	if err != nil {
@@ -56,25 +55,160 @@ func (d *Downloader) addMetadata(trackMD trackMetadata, filePath string) (err er
 		metadata["EAN"] = album.ExternalIds.EAN
 	}
 	metadata["track"] = fmt.Sprintf("%d/%d", track.TrackNumber, track.Album.TotalTracks)
+	metadata["genre"] = strings.Join(d.albumGenres(album), "; ")
+
+	var advisory uint8
+	if track.Explicit {
+		advisory = 1
+	}
+	isMP4 := strings.EqualFold(filepath.Ext(filePath), ".m4a")
+	if !isMP4 {
+		metadata["ITUNESADVISORY"] = fmt.Sprint(advisory)
+	}
+
+	if credits, err := d.getTrackCredits(trackID); err == nil {
+		metadata["composer"] = strings.Join(credits.Composers, ", ")
+		metadata["lyricist"] = strings.Join(credits.Lyricists, ", ")
+		metadata["producer"] = strings.Join(credits.Producers, ", ")
+	} else {
+		d.logger.Warnf("Failed to fetch track credits: %v, skip adding credits", err)
+	}
+
+	d.logger.Debugf("Serialized metadata: %+v", metadata)
+
+	coverFilePath, err := d.downloadCoverImage(trackCoverImages(trackMD))
+	if err != nil {
+		d.logger.Warnf("Failed to download cover image: %v, skip adding front cover", err)
+	}
+
+	if err := d.writeTags(filePath, coverFilePath, metadata); err != nil {
+		return err
+	}
+	if isMP4 {
+		// ffmpeg has no mapping to rtng, the content rating of mp4 files
+		if err := setMP4IntTags(filePath, map[string]uint8{"rtng": advisory}); err != nil {
+			return fmt.Errorf("failed to write content rating: %w", err)
+		}
+	}
+	return nil
+}
+
+// albumGenres returns the genres of an album, falling back to the genres of
+// its artists since Spotify leaves most album genre lists empty.
+func (d *Downloader) albumGenres(album albumData) []string {
 	if len(album.Genres) > 0 {
-		metadata["genre"] = album.Genres[0]
+		return album.Genres
 	}
 
-	log.Debugf("Serialized metadata: %+v", metadata)
+	artistIDs := make([]string, 0, len(album.Artists))
+	for _, artist := range album.Artists {
+		artistIDs = append(artistIDs, artist.ID)
+	}
+	artists, err := d.queryArtistsAPI(artistIDs)
+	if err != nil {
+		d.logger.Warnf("Failed to fetch artist genres: %v", err)
+		return nil
+	}
 
-	coverFileName, err := d.downloadCoverImage(trackMD)
-	coverFilePath := filepath.Join(d.outputFolder, coverFileName)
-	defer os.Remove(coverFilePath)
+	var genres []string
+	seen := make(map[string]bool)
+	for _, artist := range artists {
+		for _, genre := range artist.Genres {
+			if !seen[genre] {
+				seen[genre] = true
+				genres = append(genres, genre)
+			}
+		}
+	}
+	return genres
+}
 
+// getTrackCredits sorts the credited writers and producers of a track into
+// composers, lyricists and producers. Writers without subroles are listed
+// as composers.
+func (d *Downloader) getTrackCredits(trackID string) (WebAPICredits, error) {
+	data, err := d.queryTrackCredits(trackID)
 	if err != nil {
-		log.Warnf("Failed to download cover image: %v, skip adding front cover", err)
+		return WebAPICredits{}, err
 	}
 
-	if d.isConvertToMP3 {
+	var credits WebAPICredits
+	for _, role := range data.RoleCredits {
+		for _, artist := range role.Artists {
+			switch strings.ToLower(role.RoleTitle) {
+			case "writers":
+				isComposer, isLyricist := len(artist.Subroles) == 0, false
+				for _, subrole := range artist.Subroles {
+					switch strings.ToLower(subrole) {
+					case "composer", "writer", "songwriter":
+						isComposer = true
+					case "lyricist":
+						isLyricist = true
+					}
+				}
+				if isComposer {
+					credits.Composers = append(credits.Composers, artist.Name)
+				}
+				if isLyricist {
+					credits.Lyricists = append(credits.Lyricists, artist.Name)
+				}
+			case "producers":
+				credits.Producers = append(credits.Producers, artist.Name)
+			}
+		}
+	}
+	return credits, nil
+}
+
+func (d *Downloader) addEpisodeMetadata(episodeMD episodeMetadata, filePath string) (err error) {
+	episode := episodeMD.Data.Episode
+
+	metadata := make(map[string]string)
+	metadata["title"] = episode.Name
+	metadata["album"] = episode.Podcast.Data.Name
+	metadata["artist"] = episode.Podcast.Data.Publisher.Name
+	if metadata["artist"] == "" {
+		metadata["artist"] = episode.Creator
+	}
+	metadata["album_artist"] = metadata["artist"]
+	if len(episode.ReleaseDate.IsoString) >= 10 {
+		metadata["date"] = episode.ReleaseDate.IsoString[:10]
+	}
+	metadata["comment"] = episode.Description
+	metadata["description"] = episode.Description
+	metadata["genre"] = "Podcast"
+	isMP4 := strings.EqualFold(filepath.Ext(filePath), ".m4a")
+	if !isMP4 {
+		metadata["podcast"] = "1"
+	}
+
+	d.logger.Debugf("Serialized episode metadata: %+v", metadata)
+
+	coverFilePath, err := d.downloadCoverImage(episodeCoverImages(episodeMD))
+	if err != nil {
+		d.logger.Warnf("Failed to download cover image: %v, skip adding front cover", err)
+	}
+
+	if err := d.writeTags(filePath, coverFilePath, metadata); err != nil {
+		return err
+	}
+	if isMP4 {
+		// ffmpeg has no mapping to pcst, the podcast flag of mp4 files.
+		// stik 21 is the podcast media kind.
+		if err := setMP4IntTags(filePath, map[string]uint8{"pcst": 1, "stik": 21}); err != nil {
+			return fmt.Errorf("failed to write podcast flags: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeTags writes metadata in the tag format of the file type: ID3v2 for
+// mp3, ffmpeg's muxer tags for everything else.
+func (d *Downloader) writeTags(filePath, coverFilePath string, metadata map[string]string) error {
+	if strings.EqualFold(filepath.Ext(filePath), ".mp3") {
 		return addMp3Id3v2(filePath, coverFilePath, metadata)
-	} else {
-		return encodeMetadata(filePath, coverFilePath, metadata)
 	}
+	return d.encodeMetadata(filePath, coverFilePath, metadata)
 }
 
 func addMp3Id3v2(inputFile, coverFilePath string, metadata map[string]string) (err error) {
@@ -92,10 +226,42 @@ func addMp3Id3v2(inputFile, coverFilePath string, metadata map[string]string) (e
 	musicTag.SetArtist(metadata["artist"])
 	musicTag.SetAlbum(metadata["album"])
 	musicTag.SetYear(metadata["date"])
+	if metadata["genre"] != "" {
+		musicTag.SetGenre(metadata["genre"])
+	}
+	if metadata["comment"] != "" {
+		musicTag.AddCommentFrame(id3v2.CommentFrame{
+			Encoding: id3v2.EncodingUTF8,
+			Language: "eng",
+			Text:     metadata["comment"],
+		})
+	}
+	if metadata["composer"] != "" {
+		musicTag.AddTextFrame(musicTag.CommonID("Composer"), id3v2.EncodingUTF8, metadata["composer"])
+	}
+	if metadata["lyricist"] != "" {
+		musicTag.AddTextFrame(musicTag.CommonID("Lyricist/Text writer"), id3v2.EncodingUTF8, metadata["lyricist"])
+	}
+	for _, key := range []string{"producer", "ITUNESADVISORY"} {
+		if metadata[key] != "" {
+			musicTag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+				Encoding:    id3v2.EncodingUTF8,
+				Description: strings.ToUpper(key),
+				Value:       metadata[key],
+			})
+		}
+	}
+	if metadata["podcast"] == "1" {
+		// iTunes podcast flag, a 4-byte big-endian integer set to 1
+		musicTag.AddFrame("PCST", id3v2.UnknownFrame{Body: []byte{0, 0, 0, 1}})
+	}
 
-	picFile, err := os.ReadFile(coverFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to read album pic: %v ", err)
+	var picFile []byte
+	if coverFilePath != "" {
+		picFile, err = os.ReadFile(coverFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to read album pic: %v ", err)
+		}
 	}
 	if len(picFile) > 32 {
 		mime := http.DetectContentType(picFile[:32])
@@ -0,0 +1,288 @@
+package spotify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"math"
+	"os"
+	"strings"
+)
+
+// mp4Box is an ISO BMFF box. raw holds the encoded box as read from the
+// file, and is nil once the box has been changed.
+type mp4Box struct {
+	typ     string
+	payload []byte
+	raw     []byte
+}
+
+func parseMP4Boxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated mp4 box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("truncated mp4 box header")
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size of mp4 box %q", typ)
+		}
+		boxes = append(boxes, mp4Box{typ: typ, payload: data[header:size], raw: data[:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+func newMP4Box(typ string, payload []byte) mp4Box {
+	return mp4Box{typ: typ, payload: payload}
+}
+
+func (b mp4Box) encode() []byte {
+	if b.raw != nil {
+		return b.raw
+	}
+	var buf bytes.Buffer
+	if size := 8 + len(b.payload); size <= math.MaxUint32 {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(size))
+		buf.WriteString(b.typ)
+	} else {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(1))
+		buf.WriteString(b.typ)
+		_ = binary.Write(&buf, binary.BigEndian, uint64(size+8))
+	}
+	buf.Write(b.payload)
+	return buf.Bytes()
+}
+
+func encodeMP4Boxes(boxes []mp4Box) []byte {
+	var buf bytes.Buffer
+	for _, b := range boxes {
+		buf.Write(b.encode())
+	}
+	return buf.Bytes()
+}
+
+func findMP4Box(boxes []mp4Box, typ string) int {
+	for i, b := range boxes {
+		if b.typ == typ {
+			return i
+		}
+	}
+	return -1
+}
+
+// setMP4FreeformTags writes tags as iTunes freeform items
+// (----:com.apple.iTunes:<name>) into an m4a file, replacing items of the
+// same name. ffmpeg cannot write these, but they are where players look for
+// ReplayGain values in mp4 files.
+func setMP4FreeformTags(filePath string, tags map[string]string) error {
+	return rewriteMP4Items(filePath, func(ilst []mp4Box) []mp4Box {
+		items := ilst[:0:0]
+		for _, item := range ilst {
+			if item.typ == "----" {
+				if _, ok := tagNameFold(tags, freeformName(item)); ok {
+					continue
+				}
+			}
+			items = append(items, item)
+		}
+		for _, name := range sortedKeys(tags) {
+			items = append(items, freeformItem(name, tags[name]))
+		}
+		return items
+	})
+}
+
+// setMP4IntTags writes tags as one-byte integer items, such as rtng (the
+// content rating), pcst (the podcast flag) and stik (the media kind), into
+// an m4a file, replacing items of the same type. ffmpeg writes stik, but
+// neither rtng nor pcst.
+func setMP4IntTags(filePath string, tags map[string]uint8) error {
+	return rewriteMP4Items(filePath, func(ilst []mp4Box) []mp4Box {
+		items := ilst[:0:0]
+		for _, item := range ilst {
+			if _, ok := tags[item.typ]; !ok {
+				items = append(items, item)
+			}
+		}
+		for _, typ := range sortedKeys(tags) {
+			items = append(items, intItem(typ, tags[typ]))
+		}
+		return items
+	})
+}
+
+// rewriteMP4Items replaces the metadata items of an m4a file with those
+// edit returns for them, creating the boxes holding them if needed.
+func rewriteMP4Items(filePath string, edit func(ilst []mp4Box) []mp4Box) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	top, err := parseMP4Boxes(data)
+	if err != nil {
+		return err
+	}
+	moovIndex, mdatIndex := findMP4Box(top, "moov"), findMP4Box(top, "mdat")
+	if moovIndex < 0 {
+		return errors.New("no moov box found")
+	}
+	if findMP4Box(top, "moof") >= 0 {
+		return errors.New("fragmented mp4 files are not supported")
+	}
+
+	// work on a copy, since chunk offsets are rewritten in place
+	moov, err := parseMP4Boxes(append([]byte(nil), top[moovIndex].payload...))
+	if err != nil {
+		return err
+	}
+	udta, err := childBoxes(moov, "udta")
+	if err != nil {
+		return err
+	}
+	meta, metaHeader, err := metaBoxes(udta)
+	if err != nil {
+		return err
+	}
+	ilst, err := childBoxes(meta, "ilst")
+	if err != nil {
+		return err
+	}
+
+	meta = setChildBox(meta, newMP4Box("ilst", encodeMP4Boxes(edit(ilst))))
+	udta = setChildBox(udta, newMP4Box("meta", append(metaHeader, encodeMP4Boxes(meta)...)))
+	moov = setChildBox(moov, newMP4Box("udta", encodeMP4Boxes(udta)))
+
+	// moov grows or shrinks, which moves mdat if it comes after it
+	delta := int64(len(encodeMP4Boxes(moov))) - int64(len(top[moovIndex].payload))
+	if mdatIndex > moovIndex && delta != 0 {
+		if err := shiftChunkOffsets(moov, delta); err != nil {
+			return err
+		}
+	}
+	top[moovIndex] = newMP4Box("moov", encodeMP4Boxes(moov))
+
+	return fileutil.WriteFile(filePath, encodeMP4Boxes(top), 0644)
+}
+
+// childBoxes returns the children of the box typ in boxes, or none if there
+// is no such box.
+func childBoxes(boxes []mp4Box, typ string) ([]mp4Box, error) {
+	i := findMP4Box(boxes, typ)
+	if i < 0 {
+		return nil, nil
+	}
+	return parseMP4Boxes(boxes[i].payload)
+}
+
+// metaBoxes returns the children of the meta box in udta, creating an
+// iTunes metadata handler if there is none. The ISO meta box starts with a
+// version and flags that the QuickTime one lacks; header holds them.
+func metaBoxes(udta []mp4Box) (children []mp4Box, header []byte, err error) {
+	i := findMP4Box(udta, "meta")
+	if i < 0 {
+		hdlr := newMP4Box("hdlr", []byte{
+			0, 0, 0, 0, // version and flags
+			0, 0, 0, 0, // pre-defined
+			'm', 'd', 'i', 'r',
+			'a', 'p', 'p', 'l', 0, 0, 0, 0, 0, 0, 0, 0,
+			0, // empty name
+		})
+		return []mp4Box{hdlr}, []byte{0, 0, 0, 0}, nil
+	}
+	payload := udta[i].payload
+	if len(payload) >= 8 && string(payload[4:8]) != "hdlr" {
+		header, payload = payload[:4], payload[4:]
+	}
+	children, err = parseMP4Boxes(payload)
+	return children, append([]byte(nil), header...), err
+}
+
+func setChildBox(boxes []mp4Box, box mp4Box) []mp4Box {
+	if i := findMP4Box(boxes, box.typ); i >= 0 {
+		boxes[i] = box
+		return boxes
+	}
+	return append(boxes, box)
+}
+
+func freeformItem(name, value string) mp4Box {
+	mean := newMP4Box("mean", append([]byte{0, 0, 0, 0}, "com.apple.iTunes"...))
+	nameBox := newMP4Box("name", append([]byte{0, 0, 0, 0}, name...))
+	// type 1 is UTF-8 text, followed by an empty locale
+	data := newMP4Box("data", append([]byte{0, 0, 0, 1, 0, 0, 0, 0}, value...))
+	return newMP4Box("----", encodeMP4Boxes([]mp4Box{mean, nameBox, data}))
+}
+
+func intItem(typ string, value uint8) mp4Box {
+	// type 21 is a big-endian signed integer, followed by an empty locale
+	return newMP4Box(typ, newMP4Box("data", []byte{0, 0, 0, 21, 0, 0, 0, 0, value}).encode())
+}
+
+func freeformName(item mp4Box) string {
+	children, err := parseMP4Boxes(item.payload)
+	if err != nil {
+		return ""
+	}
+	if i := findMP4Box(children, "name"); i >= 0 && len(children[i].payload) >= 4 {
+		return string(children[i].payload[4:])
+	}
+	return ""
+}
+
+// shiftChunkOffsets adds delta to the sample chunk offsets of every track.
+func shiftChunkOffsets(moov []mp4Box, delta int64) error {
+	for _, trak := range moov {
+		if trak.typ != "trak" {
+			continue
+		}
+		stbl := []mp4Box{trak}
+		for _, typ := range []string{"trak", "mdia", "minf", "stbl"} {
+			children, err := childBoxes(stbl, typ)
+			if err != nil {
+				return err
+			}
+			stbl = children
+		}
+		for _, b := range stbl {
+			switch b.typ {
+			case "stco":
+				for i := 8; i+4 <= len(b.payload); i += 4 {
+					offset := int64(binary.BigEndian.Uint32(b.payload[i:])) + delta
+					if offset < 0 || offset > math.MaxUint32 {
+						return errors.New("chunk offset out of range")
+					}
+					binary.BigEndian.PutUint32(b.payload[i:], uint32(offset))
+				}
+			case "co64":
+				for i := 8; i+8 <= len(b.payload); i += 8 {
+					offset := int64(binary.BigEndian.Uint64(b.payload[i:])) + delta
+					binary.BigEndian.PutUint64(b.payload[i:], uint64(offset))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func tagNameFold(tags map[string]string, name string) (string, bool) {
+	for key := range tags {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
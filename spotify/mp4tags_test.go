@@ -0,0 +1,394 @@
+package spotify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+)
+
+// testBox encodes a box with a 32-bit size.
+func testBox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func testFreeform(name, value string) []byte {
+	return testBox("----",
+		testBox("mean", []byte{0, 0, 0, 0}, []byte("com.apple.iTunes")),
+		testBox("name", []byte{0, 0, 0, 0}, []byte(name)),
+		testBox("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(value)),
+	)
+}
+
+func testTextItem(typ, value string) []byte {
+	return testBox(typ, testBox("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(value)))
+}
+
+var testHdlr = testBox("hdlr", []byte{0, 0, 0, 0, 0, 0, 0, 0}, []byte("mdirappl"), make([]byte, 9))
+
+// testChunks are the chunks of the mdat box of test files. Each is found
+// again through the chunk offsets to check that they still point at it.
+var testChunks = [][]byte{[]byte("chunk-one"), []byte("chunk-two"), []byte("chunk-three")}
+
+// buildTestMP4 returns a file with a single track whose chunk offsets, in
+// an stco or co64 box, point at testChunks, and udta as the udta box of
+// moov if it is not nil.
+func buildTestMP4(moovFirst, co64 bool, udta []byte) []byte {
+	ftyp := testBox("ftyp", []byte("M4A \x00\x00\x02\x00isomM4A "))
+	mdat := testBox("mdat", testChunks...)
+
+	moov := func(offsets []uint64) []byte {
+		table := binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0}, uint32(len(offsets)))
+		typ := "stco"
+		for _, offset := range offsets {
+			if co64 {
+				table = binary.BigEndian.AppendUint64(table, offset)
+			} else {
+				table = binary.BigEndian.AppendUint32(table, uint32(offset))
+			}
+		}
+		if co64 {
+			typ = "co64"
+		}
+		stbl := testBox("stbl", testBox("stsd", make([]byte, 8)), testBox(typ, table))
+		trak := testBox("trak", testBox("tkhd", make([]byte, 84)), testBox("mdia", testBox("minf", stbl)))
+		return testBox("moov", testBox("mvhd", make([]byte, 100)), trak, udta)
+	}
+
+	// The offsets do not change the size of moov, so its size is known
+	// before they are
+	mdatStart := len(ftyp)
+	if moovFirst {
+		mdatStart += len(moov(make([]uint64, len(testChunks))))
+	}
+	offsets := make([]uint64, len(testChunks))
+	pos := uint64(mdatStart + 8)
+	for i, chunk := range testChunks {
+		offsets[i] = pos
+		pos += uint64(len(chunk))
+	}
+	if moovFirst {
+		return bytes.Join([][]byte{ftyp, moov(offsets), mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, mdat, moov(offsets)}, nil)
+}
+
+// testPath returns the children of the box found by following typs from
+// boxes.
+func testPath(t *testing.T, boxes []mp4Box, typs ...string) []mp4Box {
+	t.Helper()
+	for _, typ := range typs {
+		children, err := childBoxes(boxes, typ)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", typ, err)
+		}
+		if children == nil {
+			t.Fatalf("no %s box", typ)
+		}
+		boxes = children
+	}
+	return boxes
+}
+
+func checkChunkOffsets(t *testing.T, data []byte, moov []mp4Box) {
+	t.Helper()
+	stbl := testPath(t, moov, "trak", "mdia", "minf", "stbl")
+	var offsets []uint64
+	for _, b := range stbl {
+		switch b.typ {
+		case "stco":
+			for i := 8; i+4 <= len(b.payload); i += 4 {
+				offsets = append(offsets, uint64(binary.BigEndian.Uint32(b.payload[i:])))
+			}
+		case "co64":
+			for i := 8; i+8 <= len(b.payload); i += 8 {
+				offsets = append(offsets, binary.BigEndian.Uint64(b.payload[i:]))
+			}
+		}
+	}
+	if len(offsets) != len(testChunks) {
+		t.Fatalf("got %d chunk offsets, want %d", len(offsets), len(testChunks))
+	}
+	for i, offset := range offsets {
+		chunk := testChunks[i]
+		if offset+uint64(len(chunk)) > uint64(len(data)) || !bytes.Equal(data[offset:offset+uint64(len(chunk))], chunk) {
+			t.Errorf("chunk offset %d (%d) does not point at %q", i, offset, chunk)
+		}
+	}
+}
+
+func TestSetMP4FreeformTags(t *testing.T) {
+	isoMeta := func(items ...[]byte) []byte {
+		return testBox("udta", testBox("meta", []byte{0, 0, 0, 0}, testHdlr, testBox("ilst", items...)))
+	}
+	quickTimeMeta := func(items ...[]byte) []byte {
+		return testBox("udta", testBox("meta", testHdlr, testBox("ilst", items...)))
+	}
+
+	tests := []struct {
+		name      string
+		moovFirst bool
+		co64      bool
+		udta      []byte
+		// isoHeader is whether the meta box is expected to have the ISO
+		// version and flags
+		isoHeader bool
+		// keep are items other than the tags that must survive
+		keep []string
+	}{
+		{name: "moov after mdat, stco", isoHeader: true},
+		{name: "moov before mdat, stco", moovFirst: true, isoHeader: true},
+		{name: "moov after mdat, co64", co64: true, isoHeader: true},
+		{name: "moov before mdat, co64", moovFirst: true, co64: true, isoHeader: true},
+		{
+			name:      "existing ISO meta",
+			moovFirst: true,
+			udta:      isoMeta(testTextItem("\xa9nam", "Title")),
+			isoHeader: true,
+			keep:      []string{"\xa9nam"},
+		},
+		{
+			name:      "existing QuickTime meta",
+			moovFirst: true,
+			udta:      quickTimeMeta(testTextItem("\xa9nam", "Title")),
+			keep:      []string{"\xa9nam"},
+		},
+		{
+			name:      "existing freeform replaced",
+			moovFirst: true,
+			co64:      true,
+			udta:      isoMeta(testTextItem("\xa9nam", "Title"), testFreeform("replaygain_track_gain", "+9.00 dB"), testFreeform("OTHER", "kept")),
+			isoHeader: true,
+			keep:      []string{"\xa9nam", "----:OTHER"},
+		},
+		{
+			name:      "existing freeform replaced, moov after mdat",
+			udta:      quickTimeMeta(testFreeform("REPLAYGAIN_TRACK_GAIN", "+9.00 dB")),
+			keep:      nil,
+			isoHeader: false,
+		},
+	}
+
+	tags := map[string]string{
+		"REPLAYGAIN_TRACK_GAIN": "-6.50 dB",
+		"REPLAYGAIN_TRACK_PEAK": "0.988525",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.m4a")
+			if err := os.WriteFile(path, buildTestMP4(tt.moovFirst, tt.co64, tt.udta), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Writing twice must replace the items written the first time
+			for i := 0; i < 2; i++ {
+				if err := setMP4FreeformTags(path, tags); err != nil {
+					t.Fatalf("setMP4FreeformTags: %v", err)
+				}
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			top, err := parseMP4Boxes(data)
+			if err != nil {
+				t.Fatalf("output does not parse: %v", err)
+			}
+			if got := findMP4Box(top, "moov") < findMP4Box(top, "mdat"); got != tt.moovFirst {
+				t.Errorf("moov before mdat = %v, want %v", got, tt.moovFirst)
+			}
+			moov := testPath(t, top, "moov")
+			checkChunkOffsets(t, data, moov)
+
+			udta := testPath(t, moov, "udta")
+			metaPayload := udta[findMP4Box(udta, "meta")].payload
+			if got := string(metaPayload[4:8]) != "hdlr"; got != tt.isoHeader {
+				t.Errorf("meta has ISO header = %v, want %v", got, tt.isoHeader)
+			}
+			meta, _, err := metaBoxes(udta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if findMP4Box(meta, "hdlr") < 0 {
+				t.Error("meta has no hdlr box")
+			}
+			ilst := testPath(t, meta, "ilst")
+
+			freeform := make(map[string][]string)
+			items := make(map[string]bool)
+			for _, item := range ilst {
+				if item.typ != "----" {
+					items[item.typ] = true
+					continue
+				}
+				name := freeformName(item)
+				items["----:"+name] = true
+				children, err := parseMP4Boxes(item.payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data := children[findMP4Box(children, "data")].payload
+				freeform[strings.ToUpper(name)] = append(freeform[strings.ToUpper(name)], string(data[8:]))
+			}
+			for name, value := range tags {
+				if got := freeform[name]; len(got) != 1 || got[0] != value {
+					t.Errorf("%s = %q, want [%q]", name, got, value)
+				}
+			}
+			for _, name := range tt.keep {
+				if !items[name] {
+					t.Errorf("item %q was lost", name)
+				}
+			}
+		})
+	}
+}
+
+func TestSetMP4FreeformTagsFFprobe(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not found")
+	}
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Skip("ffprobe not found")
+	}
+
+	for _, faststart := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.m4a")
+		args := ffmpeg.KwArgs{"c:a": "aac", "t": "2"}
+		if faststart {
+			args["movflags"] = "+faststart"
+		}
+		err := ffmpeg.Input("sine=frequency=440", ffmpeg.KwArgs{"f": "lavfi"}).
+			Output(path, args).OverWriteOutput().Silent(true).Run()
+		if err != nil {
+			t.Fatalf("failed to create test file: %v", err)
+		}
+
+		if err := setMP4FreeformTags(path, map[string]string{"REPLAYGAIN_TRACK_GAIN": "-6.50 dB"}); err != nil {
+			t.Fatalf("setMP4FreeformTags: %v", err)
+		}
+		if err := VerifyFile(path, 2000, 500*time.Millisecond); err != nil {
+			t.Fatalf("faststart=%v: %v", faststart, err)
+		}
+
+		output, err := ffmpeg.ProbeWithTimeout(path, probeTimeout, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var probe struct {
+			Format struct {
+				Tags map[string]string `json:"tags"`
+			} `json:"format"`
+		}
+		if err := json.Unmarshal([]byte(output), &probe); err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for key, value := range probe.Format.Tags {
+			if strings.EqualFold(key, "REPLAYGAIN_TRACK_GAIN") && value == "-6.50 dB" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("faststart=%v: ffprobe does not show the tag, tags: %v", faststart, probe.Format.Tags)
+		}
+	}
+}
+
+// testIntItems returns the integer items of ilst by type, with every value
+// given for a type.
+func testIntItems(t *testing.T, ilst []mp4Box) map[string][]uint8 {
+	t.Helper()
+	values := make(map[string][]uint8)
+	for _, item := range ilst {
+		children, err := parseMP4Boxes(item.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := findMP4Box(children, "data")
+		if i < 0 {
+			continue
+		}
+		data := children[i].payload
+		if len(data) == 9 && binary.BigEndian.Uint32(data) == 21 {
+			values[item.typ] = append(values[item.typ], data[8])
+		}
+	}
+	return values
+}
+
+func TestSetMP4IntTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		moovFirst bool
+		udta      []byte
+		tags      map[string]uint8
+	}{
+		{name: "rtng, no metadata", tags: map[string]uint8{"rtng": 1}},
+		{
+			name:      "rtng replaced, moov before mdat",
+			moovFirst: true,
+			udta:      testBox("udta", testBox("meta", []byte{0, 0, 0, 0}, testHdlr, testBox("ilst", testTextItem("\xa9nam", "Title"), testBox("rtng", testBox("data", []byte{0, 0, 0, 21, 0, 0, 0, 0, 2}))))),
+			tags:      map[string]uint8{"rtng": 1},
+		},
+		{
+			name:      "pcst and stik of a podcast, stik replaced",
+			moovFirst: true,
+			udta:      testBox("udta", testBox("meta", []byte{0, 0, 0, 0}, testHdlr, testBox("ilst", testBox("stik", testBox("data", []byte{0, 0, 0, 21, 0, 0, 0, 0, 1}))))),
+			tags:      map[string]uint8{"pcst": 1, "stik": 21},
+		},
+		{
+			name: "rtng cleared, moov after mdat",
+			udta: testBox("udta", testBox("meta", testHdlr, testBox("ilst", testBox("rtng", testBox("data", []byte{0, 0, 0, 21, 0, 0, 0, 0, 1}))))),
+			tags: map[string]uint8{"rtng": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.m4a")
+			if err := os.WriteFile(path, buildTestMP4(tt.moovFirst, false, tt.udta), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := setMP4IntTags(path, tt.tags); err != nil {
+				t.Fatalf("setMP4IntTags: %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			top, err := parseMP4Boxes(data)
+			if err != nil {
+				t.Fatalf("output does not parse: %v", err)
+			}
+			moov := testPath(t, top, "moov")
+			checkChunkOffsets(t, data, moov)
+			meta, _, err := metaBoxes(testPath(t, moov, "udta"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ilst := testPath(t, meta, "ilst")
+
+			values := testIntItems(t, ilst)
+			for typ, want := range tt.tags {
+				if got := values[typ]; len(got) != 1 || got[0] != want {
+					t.Errorf("%s = %v, want [%d]", typ, got, want)
+				}
+			}
+			if tt.udta != nil && bytes.Contains(tt.udta, []byte("\xa9nam")) && findMP4Box(ilst, "\xa9nam") < 0 {
+				t.Error("item \xa9nam was lost")
+			}
+		})
+	}
+}
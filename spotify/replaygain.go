@@ -0,0 +1,191 @@
+package spotify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/bogem/id3v2"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// replayGainReference is the loudness ReplayGain 2.0 normalises to, in LUFS.
+const replayGainReference = -18.0
+
+var (
+	integratedLoudnessRegexp = regexp.MustCompile(`I:\s+(-?[0-9.]+|-inf) LUFS`)
+	truePeakRegexp           = regexp.MustCompile(`Peak:\s+(-?[0-9.]+|-inf) dBFS`)
+)
+
+// loudness is the EBU R 128 measurement of one or more files.
+type loudness struct {
+	integrated float64 // LUFS
+	truePeak   float64 // dBFS
+}
+
+func (l loudness) gain() string {
+	return fmt.Sprintf("%.2f dB", replayGainReference-l.integrated)
+}
+
+func (l loudness) peak() string {
+	return fmt.Sprintf("%.6f", math.Pow(10, l.truePeak/20))
+}
+
+func (l loudness) trackTags() map[string]string {
+	return map[string]string{
+		"REPLAYGAIN_TRACK_GAIN": l.gain(),
+		"REPLAYGAIN_TRACK_PEAK": l.peak(),
+	}
+}
+
+func (l loudness) albumTags() map[string]string {
+	return map[string]string{
+		"REPLAYGAIN_ALBUM_GAIN": l.gain(),
+		"REPLAYGAIN_ALBUM_PEAK": l.peak(),
+	}
+}
+
+// measureLoudness runs ffmpeg's ebur128 filter over files played one after
+// another, which gives the album loudness when files are the tracks of an
+// album.
+func (d *Downloader) measureLoudness(files ...string) (loudness, error) {
+	if len(files) == 0 {
+		return loudness{}, errors.New("no files to measure")
+	}
+	var streams []*ffmpeg.Stream
+	for _, file := range files {
+		streams = append(streams, ffmpeg.Input(file).Audio())
+	}
+	stream := streams[0]
+	if len(streams) > 1 {
+		stream = ffmpeg.Concat(streams, ffmpeg.KwArgs{"v": 0, "a": 1})
+	}
+
+	var output bytes.Buffer
+	var stderr io.Writer = &output
+	if d.logger.Enabled(log.LevelDebug) {
+		stderr = io.MultiWriter(&output, os.Stderr)
+	}
+	err := stream.Filter("ebur128", ffmpeg.Args{}, ffmpeg.KwArgs{"peak": "true"}).
+		Output("-", ffmpeg.KwArgs{"format": "null"}).
+		Silent(true).WithErrorOutput(stderr).Run()
+	if err != nil {
+		return loudness{}, fmt.Errorf("failed to measure loudness: %v", err)
+	}
+	return parseLoudness(output.String())
+}
+
+// parseLoudness reads the summary ebur128 prints when it finishes. The
+// per-frame lines before it use the same labels, so the last match wins.
+func parseLoudness(output string) (l loudness, err error) {
+	integrated := integratedLoudnessRegexp.FindAllStringSubmatch(output, -1)
+	peak := truePeakRegexp.FindAllStringSubmatch(output, -1)
+	if len(integrated) == 0 || len(peak) == 0 {
+		return l, errors.New("no loudness summary in ffmpeg output")
+	}
+	if l.integrated, err = parseDecibels(integrated[len(integrated)-1][1]); err != nil {
+		return l, err
+	}
+	if l.truePeak, err = parseDecibels(peak[len(peak)-1][1]); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+func parseDecibels(s string) (float64, error) {
+	if s == "-inf" {
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// addReplayGain measures a downloaded file and writes its track gain tags.
+func (d *Downloader) addReplayGain(filePath string) error {
+	l, err := d.measureLoudness(filePath)
+	if err != nil {
+		return err
+	}
+	d.logger.Debugf("Loudness of [%s]: %.1f LUFS, true peak %.1f dBFS", filePath, l.integrated, l.truePeak)
+	return d.writeReplayGainTags(filePath, l.trackTags())
+}
+
+// addAlbumReplayGain measures the tracks of an album, filePaths, together
+// and writes the album gain tags to each of tagPaths.
+func (d *Downloader) addAlbumReplayGain(filePaths, tagPaths []string) error {
+	l, err := d.measureLoudness(filePaths...)
+	if err != nil {
+		return err
+	}
+	d.logger.Debugf("Album loudness: %.1f LUFS, true peak %.1f dBFS", l.integrated, l.truePeak)
+	for _, filePath := range tagPaths {
+		if err := d.writeReplayGainTags(filePath, l.albumTags()); err != nil {
+			return fmt.Errorf("failed to tag [%s]: %w", filePath, err)
+		}
+	}
+	return nil
+}
+
+// writeReplayGainTags adds tags to a file without touching its other tags:
+// as TXXX frames in mp3, iTunes freeform items in m4a and Vorbis comments
+// otherwise.
+func (d *Downloader) writeReplayGainTags(filePath string, tags map[string]string) error {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp3":
+		return setID3UserTextFrames(filePath, tags)
+	case ".m4a":
+		return setMP4FreeformTags(filePath, tags)
+	default:
+		return d.encodeMetadata(filePath, "", tags)
+	}
+}
+
+// setID3UserTextFrames writes tags as TXXX frames, replacing frames with
+// the same description.
+func setID3UserTextFrames(filePath string, tags map[string]string) error {
+	musicTag, err := id3v2.Open(filePath, id3v2.Options{Parse: true})
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %v", err)
+	}
+	defer musicTag.Close()
+
+	frames := musicTag.GetFrames(musicTag.CommonID("User defined text information frame"))
+	musicTag.DeleteFrames(musicTag.CommonID("User defined text information frame"))
+	for _, frame := range frames {
+		udtf, ok := frame.(id3v2.UserDefinedTextFrame)
+		if !ok {
+			continue
+		}
+		if _, replaced := tagNameFold(tags, udtf.Description); !replaced {
+			musicTag.AddUserDefinedTextFrame(udtf)
+		}
+	}
+	for _, name := range sortedKeys(tags) {
+		musicTag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+			Encoding:    id3v2.EncodingUTF8,
+			Description: name,
+			Value:       tags[name],
+		})
+	}
+
+	if err := musicTag.Save(); err != nil {
+		return fmt.Errorf("failed to save id3v2: %v ", err)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,7 +3,7 @@ package spotify
 import (
 	"bytes"
 	"fmt"
-	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"github.com/XiaoMengXinX/sp-dl-go/token"
 	"io"
 	"net/http"
 	"os"
@@ -12,7 +12,23 @@ import (
 )
 
 func (d *Downloader) makeRequest(method, url string, body []byte) ([]byte, error) {
-	accessToken, _ := d.TokenManager.GetAccessToken()
+	for retried := false; ; retried = true {
+		tok, err := d.TokenSource.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get access token: %w", err)
+		}
+
+		data, status, err := d.doRequest(method, url, body, tok.AccessToken)
+		if inv, ok := d.TokenSource.(token.Invalidator); ok && status == http.StatusUnauthorized && !retried {
+			d.logger.Debugf("Request to [%s] unauthorized, retrying with a new token", url)
+			inv.Invalidate(tok.AccessToken)
+			continue
+		}
+		return data, err
+	}
+}
+
+func (d *Downloader) doRequest(method, url string, body []byte, accessToken string) ([]byte, int, error) {
 	var requestBody io.Reader
 	if body != nil {
 		requestBody = bytes.NewBuffer(body)
@@ -20,33 +36,34 @@ func (d *Downloader) makeRequest(method, url string, body []byte) ([]byte, error
 
 	req, err := http.NewRequest(method, url, requestBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36")
 	req.Header.Set("Accept", "application/json")
 
-	if acceptLanguage := d.TokenManager.ConfigManager.Get().AcceptLanguage; len(acceptLanguage) > 0 {
+	if acceptLanguage := d.acceptLanguage(); len(acceptLanguage) > 0 {
 		req.Header.Set("Accept-Language", generateAcceptLanguageHeader(acceptLanguage))
 	}
 
-	log.Debugf("[%s] %s", method, url)
-	log.Debugf("Headers: %+v", req.Header)
+	d.logger.Debugf("[%s] %s", method, url)
+	d.logger.Debugf("Headers: %+v", req.Header)
 
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, 0, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request to [%s] failed with status [%d]", url, resp.StatusCode)
+		return nil, resp.StatusCode, fmt.Errorf("request to [%s] failed with status [%d]", url, resp.StatusCode)
 	}
-	return io.ReadAll(resp.Body)
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.StatusCode, err
 }
 
-func (d *Downloader) downloadURL(url, filename string) error {
+func (d *Downloader) downloadURL(url, filePath string) error {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
@@ -55,9 +72,9 @@ func (d *Downloader) downloadURL(url, filename string) error {
 	req.Header.Add("Accept", "*/*")
 	req.Header.Add("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36")
 
-	log.Debugf("Start to download [%s]", filename)
-	log.Debugf("[%s] %s", "GET", url)
-	log.Debugf("Headers: %+v", req.Header)
+	d.logger.Debugf("Start to download [%s]", filePath)
+	d.logger.Debugf("[%s] %s", "GET", url)
+	d.logger.Debugf("Headers: %+v", req.Header)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -70,11 +87,11 @@ func (d *Downloader) downloadURL(url, filename string) error {
 		return fmt.Errorf("download failed with http code: %d", resp.StatusCode)
 	}
 
-	if err := os.MkdirAll(d.outputFolder, os.ModePerm); err != nil {
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create directory: %v", err)
 	}
 
-	file, err := os.Create(filepath.Join(d.outputFolder, filename))
+	file, err := os.Create(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %v", err)
 	}
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	log "github.com/XiaoMengXinX/sp-dl-go/logger"
 	"github.com/XiaoMengXinX/sp-dl-go/token"
+	"log/slog"
 	"net/http"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -19,6 +23,9 @@ const (
 	Quality320Vorbis  = "OGG_VORBIS_320"
 )
 
+// DefaultOutputTemplate names files after the title and first artist.
+const DefaultOutputTemplate = "{name} - {artist}"
+
 var (
 	mp4FormatSet = map[string]bool{
 		Quality128MP4:     true,
@@ -36,33 +43,175 @@ var (
 
 type Downloader struct {
 	TokenManager *token.Manager
-
-	outputFolder string
-	quality      string
-	clientBases  []string
-	licenseURL   string
-
-	isConvertToMP3       bool
+	// TokenSource supplies the access tokens of API requests. It is the
+	// TokenManager unless replaced with SetTokenSource.
+	TokenSource token.TokenSource
+
+	outputFolder   string
+	outputTemplate string
+	filenamePolicy FilenamePolicy
+	filenames      map[string]string
+	filenamesMu    sync.Mutex
+	quality        string
+	clientBases    []string
+	licenseURL     string
+	feedBaseURL    string
+	languages      []string
+
+	coverSize     coverSize
+	coverCacheDir string
+	coverFiles    []string
+	covers        map[string]string
+	coverFetches  map[string]*coverFetch
+	coversMu      sync.Mutex
+
+	metadataCache *metadataCache
+
+	convertTarget        *ConvertTarget
 	isSkipAddingMetadata bool
+	isReplayGain         bool
+	isVerify             bool
+	verifyTolerance      time.Duration
+	retries              int
+	archivePath          string
+	isWriteManifests     bool
+	dedupPolicy          string
+	libraryIndexPath     string
+	syncRemoval          string
+	trashDir             string
+	watchStatePath       string
+
+	hooks []Hook
+
+	logger *log.Logger
 }
 
 func NewDownloader() *Downloader {
+	tm := token.NewTokenManager()
+	logger := log.New(nil)
 	return &Downloader{
-		TokenManager: token.NewTokenManager(),
-		quality:      Quality128MP4Dual,
-		outputFolder: filepath.Clean("./output"),
+		TokenManager:    tm,
+		TokenSource:     tm,
+		quality:         Quality128MP4Dual,
+		outputTemplate:  DefaultOutputTemplate,
+		filenamePolicy:  DefaultFilenamePolicy(),
+		filenames:       make(map[string]string),
+		outputFolder:    filepath.Clean("./output"),
+		coverCacheDir:   defaultCoverCacheDir(),
+		covers:          make(map[string]string),
+		coverFetches:    make(map[string]*coverFetch),
+		metadataCache:   newMetadataCache(logger),
+		verifyTolerance: DefaultVerifyTolerance,
+		dedupPolicy:     DedupOff,
+		syncRemoval:     SyncTrash,
+		logger:          logger,
 	}
 }
 
+// Clone returns a Downloader with the settings, hooks, token source,
+// metadata cache and initialised clients of d, whose settings can be changed
+// without affecting d. Clones can download at the same time.
+func (d *Downloader) Clone() *Downloader {
+	c := &Downloader{
+		TokenManager:         d.TokenManager,
+		TokenSource:          d.TokenSource,
+		outputFolder:         d.outputFolder,
+		outputTemplate:       d.outputTemplate,
+		filenamePolicy:       d.filenamePolicy,
+		filenames:            make(map[string]string),
+		quality:              d.quality,
+		clientBases:          d.clientBases,
+		licenseURL:           d.licenseURL,
+		feedBaseURL:          d.feedBaseURL,
+		languages:            d.languages,
+		coverSize:            d.coverSize,
+		coverCacheDir:        d.coverCacheDir,
+		coverFiles:           append([]string(nil), d.coverFiles...),
+		covers:               make(map[string]string),
+		coverFetches:         make(map[string]*coverFetch),
+		metadataCache:        d.metadataCache,
+		isSkipAddingMetadata: d.isSkipAddingMetadata,
+		isReplayGain:         d.isReplayGain,
+		isVerify:             d.isVerify,
+		verifyTolerance:      d.verifyTolerance,
+		retries:              d.retries,
+		archivePath:          d.archivePath,
+		isWriteManifests:     d.isWriteManifests,
+		dedupPolicy:          d.dedupPolicy,
+		libraryIndexPath:     d.libraryIndexPath,
+		syncRemoval:          d.syncRemoval,
+		trashDir:             d.trashDir,
+		watchStatePath:       d.watchStatePath,
+		hooks:                append([]Hook(nil), d.hooks...),
+		logger:               d.logger,
+	}
+	if d.convertTarget != nil {
+		target := *d.convertTarget
+		c.convertTarget = &target
+	}
+	return c
+}
+
 func (d *Downloader) Initialize() *Downloader {
-	d.TokenManager.ConfigManager.Initialize()
-	d.TokenManager.QuerySpDc()
-	d.clientBases = requestClientBases()
-	d.licenseURL = buildLicenseURL(d.clientBases)
-	_ = readCDMs()
+	d.Authenticate()
+	d.clientBases = d.requestClientBases()
+	d.licenseURL = d.buildLicenseURL(d.clientBases)
+	_ = d.readCDMs()
 	if err := checkDirExist(d.outputFolder); err != nil {
-		log.Fatalln(err)
+		d.logger.Fatalln(err)
+	}
+	return d
+}
+
+// Authenticate loads the config and obtains an access token, which is all
+// that is needed for metadata lookups without downloading.
+func (d *Downloader) Authenticate() *Downloader {
+	if tm, ok := d.TokenSource.(*token.Manager); ok {
+		tm.ConfigManager.Initialize()
+		if err := tm.QuerySpDc(); err != nil {
+			d.logger.Fatalf("Authentication failed: %v", err)
+		}
+		return d
+	}
+	if _, err := d.TokenSource.Token(); err != nil {
+		d.logger.Fatalf("Authentication failed: %v", err)
+	}
+	return d
+}
+
+// SetLogger routes the log output of the Downloader, its TokenManager and
+// config manager to l instead of the logger package. Registered secrets are
+// still redacted.
+func (d *Downloader) SetLogger(l *slog.Logger) *Downloader {
+	d.logger = log.New(l)
+	d.metadataCache.logger = d.logger
+	d.TokenManager.Logger = l
+	d.TokenManager.ConfigManager.SetLogger(l)
+	return d
+}
+
+// SetAcceptLanguage sets the languages metadata is requested in, e.g. "ja"
+// or "en-US;q=0.8". They are the AcceptLanguage of the config of the
+// TokenManager if not set, when it is the token source.
+func (d *Downloader) SetAcceptLanguage(languages ...string) *Downloader {
+	d.languages = languages
+	return d
+}
+
+func (d *Downloader) acceptLanguage() []string {
+	if d.languages != nil {
+		return d.languages
+	}
+	if tm, ok := d.TokenSource.(*token.Manager); ok && tm.ConfigManager != nil {
+		return tm.ConfigManager.AcceptLanguage()
 	}
+	return nil
+}
+
+// SetTokenSource replaces the sp_dc cookie flow of the TokenManager with
+// another source of access tokens.
+func (d *Downloader) SetTokenSource(ts token.TokenSource) *Downloader {
+	d.TokenSource = ts
 	return d
 }
 
@@ -79,20 +228,132 @@ func (d *Downloader) SetOutputPath(outputPath string) *Downloader {
 	return nil
 }
 
+// SetOutputTemplate sets how downloaded files are named inside the output
+// folder. The placeholders {name}, {artist}, {album}, {track}, {disc} and
+// {id} are replaced with the item's metadata, and slashes create folders,
+// e.g. "{album}/{track} - {name}".
+func (d *Downloader) SetOutputTemplate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("output template is empty")
+	}
+	d.outputTemplate = template
+	return nil
+}
+
+// ConvertToMP3 converts downloaded files to mp3 at the bitrate of the
+// download quality. It is a shorthand for SetConvert("mp3").
 func (d *Downloader) ConvertToMP3(b bool) *Downloader {
-	d.isConvertToMP3 = b
+	d.convertTarget = nil
+	if b {
+		d.convertTarget = &ConvertTarget{Codec: CodecMP3}
+	}
 	return d
 }
 
+// SetConvert converts downloaded files to the target described by spec, see
+// ParseConvertTarget. An empty spec keeps the downloaded format.
+func (d *Downloader) SetConvert(spec string) error {
+	if spec == "" {
+		d.convertTarget = nil
+		return nil
+	}
+	target, err := ParseConvertTarget(spec)
+	if err != nil {
+		return err
+	}
+	d.convertTarget = &target
+	return nil
+}
+
 func (d *Downloader) SkipAddingMetadata(b bool) *Downloader {
 	d.isSkipAddingMetadata = b
 	return d
 }
 
+// ReplayGain measures the loudness of downloaded files and writes ReplayGain
+// 2.0 track tags, plus album tags when a whole album is downloaded.
+func (d *Downloader) ReplayGain(b bool) *Downloader {
+	d.isReplayGain = b
+	return d
+}
+
+// Verify checks each downloaded file with ffprobe once it is decrypted and
+// converted, see VerifyFile. A file failing the check is removed and its
+// download fails with a *VerifyError.
+func (d *Downloader) Verify(b bool) *Downloader {
+	d.isVerify = b
+	return d
+}
+
+// SetVerifyTolerance sets how far the length of a verified file may be from
+// the length of the track.
+func (d *Downloader) SetVerifyTolerance(tolerance time.Duration) *Downloader {
+	d.verifyTolerance = tolerance
+	return d
+}
+
+// SetRetries sets how many more times an item is downloaded after it fails
+// verification.
+func (d *Downloader) SetRetries(n int) *Downloader {
+	d.retries = max(n, 0)
+	return d
+}
+
+// SetFeedBaseURL sets the URL prefix of enclosure links in generated podcast
+// feeds. Links are relative to the feed file when it is empty.
+func (d *Downloader) SetFeedBaseURL(baseURL string) *Downloader {
+	d.feedBaseURL = baseURL
+	return d
+}
+
+// SetCoverSize selects which artwork size is embedded: "largest", a width
+// such as "640" (the closest available size is used) or a maximum dimension
+// such as "max:1000".
+func (d *Downloader) SetCoverSize(size string) error {
+	s, err := parseCoverSize(size)
+	if err != nil {
+		return err
+	}
+	d.coverSize = s
+	return nil
+}
+
+// SetCoverCacheDir sets the folder where downloaded covers are kept, so that
+// tracks of the same album share one download across runs.
+func (d *Downloader) SetCoverCacheDir(dir string) *Downloader {
+	d.coverCacheDir = filepath.Clean(dir)
+	return d
+}
+
+// SetCoverFiles sets the file names (e.g. cover.jpg, folder.jpg) the cover is
+// saved as next to each downloaded file. Spaces around names are trimmed
+// and empty names dropped.
+func (d *Downloader) SetCoverFiles(names ...string) *Downloader {
+	d.coverFiles = nil
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			d.coverFiles = append(d.coverFiles, name)
+		}
+	}
+	return d
+}
+
+// SetMetadataCache keeps metadata responses in dir for ttl, in addition to
+// the in-memory cache every Downloader uses, whose entries also expire after
+// ttl. An empty dir disables the on-disk store.
+func (d *Downloader) SetMetadataCache(dir string, ttl time.Duration) *Downloader {
+	if dir != "" {
+		dir = filepath.Clean(dir)
+	}
+	d.metadataCache.dir = dir
+	d.metadataCache.ttl = ttl
+	return d
+}
+
 func (d *Downloader) GetTracks(url string) ([]string, error) {
 	url, idType, err := GetIDType(url)
 	if err != nil {
-		log.Debugf("Get IDType Failed: %v", err)
+		d.logger.Debugf("Get IDType Failed: %v", err)
 		return nil, err
 	}
 	switch idType {
@@ -102,6 +363,8 @@ func (d *Downloader) GetTracks(url string) ([]string, error) {
 		return d.fetchPlaylistTracks(url, 0, []string{})
 	case SHOW:
 		return d.fetchShowEpisodes(url, 0, []string{})
+	case ARTIST:
+		return d.fetchArtistTracks(url)
 	default:
 		return []string{url}, nil
 	}
@@ -141,6 +404,38 @@ func (d *Downloader) fetchPlaylistTracks(playlistID string, offset int, tracks [
 	return tracks, nil
 }
 
+// fetchArtistAlbums returns the albums and singles of an artist.
+func (d *Downloader) fetchArtistAlbums(artistID string) ([]string, error) {
+	var albums []string
+	for offset := 0; ; offset += 50 {
+		artistAlbums, err := d.queryArtistAlbumsAPI(artistID, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range artistAlbums.Items {
+			albums = append(albums, item.ID)
+		}
+		if len(artistAlbums.Items) < 50 {
+			return albums, nil
+		}
+	}
+}
+
+func (d *Downloader) fetchArtistTracks(artistID string) ([]string, error) {
+	albums, err := d.fetchArtistAlbums(artistID)
+	if err != nil {
+		return nil, err
+	}
+	var tracks []string
+	for _, album := range albums {
+		tracks, err = d.fetchAlbumTracks(album, 0, tracks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tracks, nil
+}
+
 func (d *Downloader) fetchShowEpisodes(showID string, offset int, episodes []string) ([]string, error) {
 	showData, err := d.queryShowTracksAPI(showID, offset)
 	if err != nil {
@@ -159,9 +454,9 @@ func (d *Downloader) fetchShowEpisodes(showID string, offset int, episodes []str
 
 func (d *Downloader) getTrackMetadata(trackID string) (name string, artist string, fileID string, metadata trackMetadata, err error) {
 	url := fmt.Sprintf("https://spclient.wg.spotify.com/metadata/4/track/%s", SpIDToHex(trackID))
-	resp, err := d.makeRequest(http.MethodGet, url, nil)
+	resp, err := d.cachedRequest(d.trackMetadataCacheKey(trackID), url)
 	if err != nil {
-		log.Debugf("Fetch track metadata Failed: %v", err)
+		d.logger.Debugf("Fetch track metadata Failed: %v", err)
 		return "", "", "", metadata, err
 	}
 
@@ -173,7 +468,7 @@ func (d *Downloader) getTrackMetadata(trackID string) (name string, artist strin
 		artist = metadata.Artists[0].Name
 	}
 
-	log.Debugf("Available formats: %+v", getAllFiles(metadata))
+	d.logger.Debugf("Available formats: %+v", getAllFiles(metadata))
 
 	fileID, err = d.selectFromQuality(getAllFiles(metadata))
 	if err != nil {
@@ -203,7 +498,7 @@ func (d *Downloader) getEpisodeMetadata(episodeID string) (name string, creator
 	}
 	resp, err := d.makeRequest(http.MethodGet, url+"?"+buildQueryParams(params), nil)
 	if err != nil {
-		log.Debugf("Fetch episode metadata Failed: %v", err)
+		d.logger.Debugf("Fetch episode metadata Failed: %v", err)
 		return "", "", "", metadata, err
 	}
 
@@ -230,7 +525,7 @@ func (d *Downloader) requestCDNURL(fileID string) (string, error) {
 
 	respBody, err := d.makeRequest(http.MethodGet, url+"?"+params, nil)
 	if err != nil {
-		log.Debugf("Fetch CDN URL Failed: %v", err)
+		d.logger.Debugf("Fetch CDN URL Failed: %v", err)
 		return "", err
 	}
 
@@ -240,7 +535,7 @@ func (d *Downloader) requestCDNURL(fileID string) (string, error) {
 	if len(cdnResponse.CdnURL) == 0 {
 		return "", fmt.Errorf("no CDN URL found in response")
 	}
-	log.Debugf("Get CDN URL successfully: %v", cdnResponse.CdnURL)
+	d.logger.Debugf("Get CDN URL successfully: %v", cdnResponse.CdnURL)
 
 	return cdnResponse.CdnURL[0], nil
 }
@@ -0,0 +1,282 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Policies for files of items removed from a synced collection.
+const (
+	// SyncTrash moves removed files into the trash folder.
+	SyncTrash = "trash"
+	// SyncDelete deletes removed files.
+	SyncDelete = "delete"
+)
+
+// SyncStateName is the name of the file in the output folder that keeps the
+// items of every collection synced into it.
+const SyncStateName = "sync.json"
+
+// DefaultTrashName is the name of the trash folder in the output folder,
+// unless set with SetSyncRemoval.
+const DefaultTrashName = ".trash"
+
+// syncVersion is the format version of sync state files.
+const syncVersion = 1
+
+type syncState struct {
+	Version int `json:"version"`
+	// Collections are keyed by type and ID, e.g. "playlist:37i9dQ...".
+	Collections map[string]syncCollection `json:"collections"`
+}
+
+type syncCollection struct {
+	Type  IDType     `json:"type"`
+	ID    string     `json:"id"`
+	Items []HookItem `json:"items"`
+	Time  time.Time  `json:"time"`
+}
+
+// SyncResult is what Sync changed in the output folder.
+type SyncResult struct {
+	// Added are the items downloaded.
+	Added []HookItem
+	// Removed are the items no longer in the collection.
+	Removed []SyncRemoved
+	// Failed are the items that could not be downloaded or removed.
+	Failed []HookItem
+	// Unchanged is the number of items already downloaded.
+	Unchanged int
+}
+
+// SyncRemoved is an item removed from a synced collection.
+type SyncRemoved struct {
+	ID   string
+	Path string
+	// MovedTo is where the file was moved in the trash. It is empty if the
+	// file was deleted, or kept because something else uses it.
+	MovedTo string
+}
+
+// SetSyncRemoval selects what Sync does with the files of removed items:
+// SyncTrash moves them into trashDir, or DefaultTrashName in the output
+// folder if it is empty, and SyncDelete deletes them.
+func (d *Downloader) SetSyncRemoval(policy string, trashDir string) error {
+	switch policy {
+	case SyncTrash, SyncDelete:
+	default:
+		return fmt.Errorf("unknown sync removal policy %q, expected %s or %s", policy, SyncTrash, SyncDelete)
+	}
+	if trashDir != "" {
+		trashDir = filepath.Clean(trashDir)
+	}
+	d.syncRemoval = policy
+	d.trashDir = trashDir
+	return nil
+}
+
+// Sync mirrors an album, playlist or show into the output folder: items
+// added since the previous sync, or whose file is gone, are downloaded, and
+// the files of removed items are moved to the trash or deleted. The m3u8
+// playlist of albums and playlists is rewritten in the current order.
+func (d *Downloader) Sync(url string) (SyncResult, error) {
+	var result SyncResult
+	id, idType, err := GetIDType(url)
+	if err != nil {
+		return result, err
+	}
+	if idType != ALBUM && idType != PLAYLIST && idType != SHOW {
+		return result, fmt.Errorf("only albums, playlists and shows can be synced, got %s", idType)
+	}
+
+	tracks, err := d.GetTracks(url)
+	if err != nil {
+		return result, fmt.Errorf("failed to get tracks: %v", err)
+	}
+
+	if err := checkDirExist(d.outputFolder); err != nil {
+		return result, err
+	}
+	statePath := filepath.Join(d.outputFolder, SyncStateName)
+	unlock, err := fileutil.Lock(statePath)
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	state, err := readSyncState(statePath)
+	if err != nil {
+		return result, err
+	}
+	key := fmt.Sprintf("%s:%s", idType, id)
+	previous := state.Collections[key]
+
+	known := make(map[string]HookItem)
+	for _, item := range previous.Items {
+		known[item.ID] = item
+	}
+	current := make(map[string]bool)
+	var missing []string
+	for _, track := range tracks {
+		if current[track] {
+			continue
+		}
+		current[track] = true
+		if item, ok := known[track]; ok && fileExists(item.Path) {
+			result.Unchanged++
+		} else {
+			missing = append(missing, track)
+		}
+	}
+
+	d.logger.Infof("Syncing %s [%s]: %d new, %d unchanged", idType, id, len(missing), result.Unchanged)
+	downloaded := d.downloadItems(context.Background(), id, idType, missing)
+	for _, item := range downloaded {
+		if item.Error != "" {
+			result.Failed = append(result.Failed, item)
+			continue
+		}
+		known[item.ID] = item
+		result.Added = append(result.Added, item)
+	}
+
+	collection := syncCollection{Type: idType, ID: id, Time: time.Now().UTC()}
+	for _, track := range tracks {
+		if item, ok := known[track]; ok && fileExists(item.Path) {
+			collection.Items = append(collection.Items, HookItem{ID: track, Path: item.Path, Reused: item.Reused})
+		}
+	}
+	state.Collections[key] = collection
+
+	for _, item := range previous.Items {
+		if current[item.ID] {
+			continue
+		}
+		movedTo, err := d.removeSyncedFile(item.Path, state)
+		if err != nil {
+			result.Failed = append(result.Failed, HookItem{ID: item.ID, Path: item.Path, Error: err.Error()})
+			continue
+		}
+		result.Removed = append(result.Removed, SyncRemoved{ID: item.ID, Path: item.Path, MovedTo: movedTo})
+	}
+
+	if idType == ALBUM && len(result.Added) > 0 && len(collection.Items) == len(current) {
+		d.albumReplayGain(collection.Items)
+	}
+	if idType == ALBUM || idType == PLAYLIST {
+		if err := d.writeBatchPlaylist(id, idType, collection.Items); err != nil {
+			d.logger.Errorf("Failed to write playlist: %v", err)
+		}
+	}
+
+	state.Version = syncVersion
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	if err := fileutil.WriteFile(statePath, data, 0644); err != nil {
+		return result, fmt.Errorf("failed to save sync state: %w", err)
+	}
+
+	return result, d.runHooks(HookAfterBatch, HookPayload{Type: idType, ID: id, Items: downloaded})
+}
+
+func readSyncState(path string) (syncState, error) {
+	state := syncState{Collections: make(map[string]syncCollection)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("unable to parse sync state [%s]: %w", path, err)
+	}
+	if state.Version > syncVersion {
+		return state, fmt.Errorf("sync state [%s] has version %d, newer than the supported version %d", path, state.Version, syncVersion)
+	}
+	if state.Collections == nil {
+		state.Collections = make(map[string]syncCollection)
+	}
+	return state, nil
+}
+
+// removeSyncedFile trashes or deletes the file of a removed item and returns
+// where it was moved to. Files outside the output folder, such as library
+// files referenced with DedupReference, and files still used by a synced
+// collection are kept.
+func (d *Downloader) removeSyncedFile(path string, state syncState) (string, error) {
+	absFolder, err := filepath.Abs(d.outputFolder)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absFolder, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		d.logger.Infof("Keep [%s], it is outside the output folder", path)
+		return "", nil
+	}
+	for _, collection := range state.Collections {
+		for _, item := range collection.Items {
+			if item.Path == path {
+				d.logger.Infof("Keep [%s], it is still in %s [%s]", path, collection.Type, collection.ID)
+				return "", nil
+			}
+		}
+	}
+	if !fileExists(path) {
+		return "", nil
+	}
+
+	var moved string
+	if d.syncRemoval == SyncDelete {
+		err = os.Remove(path)
+	} else {
+		trashDir := d.trashDir
+		if trashDir == "" {
+			trashDir = filepath.Join(d.outputFolder, DefaultTrashName)
+		}
+		moved = filepath.Join(trashDir, time.Now().Format("20060102-150405"), rel)
+		err = moveFile(path, moved)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := d.removeFromManifest(path); err != nil {
+		d.logger.Warnf("Failed to remove [%s] from the manifest: %v", path, err)
+	}
+
+	// Remove folders left empty, such as the folder of an album
+	for dir := filepath.Dir(absPath); dir != absFolder && strings.HasPrefix(dir, absFolder); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return moved, nil
+}
+
+// moveFile renames src to dst, copying it if they are on different file
+// systems.
+func moveFile(src, dst string) error {
+	if err := checkDirExist(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
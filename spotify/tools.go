@@ -3,11 +3,9 @@ package spotify
 import (
 	"encoding/hex"
 	"fmt"
-	log "github.com/XiaoMengXinX/sp-dl-go/logger"
 	"math/big"
 	"net/url"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -103,33 +101,6 @@ func formatArtistsStr(artists []artistData) string {
 	return strings.Join(artistNames, ", ")
 }
 
-func cleanFilename(filename string) string {
-	osType := os.Getenv("GOOS")
-
-	var illegalChars *regexp.Regexp
-	switch osType {
-	case "darwin":
-		illegalChars = regexp.MustCompile(`[:\x00-\x1F]`)
-	case "linux":
-		illegalChars = regexp.MustCompile(`[\x00/]`)
-	default:
-		illegalChars = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1F]`)
-	}
-
-	cleaned := illegalChars.ReplaceAllString(filename, "")
-	cleaned = strings.TrimSpace(cleaned)
-
-	if cleaned == "." || cleaned == ".." || cleaned == "" {
-		return "null"
-	}
-
-	if len(cleaned) > 250 {
-		cleaned = cleaned[:250]
-	}
-
-	return cleaned
-}
-
 func generateAcceptLanguageHeader(languages []string) string {
 	var result []string
 
@@ -172,11 +143,11 @@ func (d *Downloader) selectFromQuality(entries []fileEntry) (string, error) {
 			return entry.testFileIDOrFileId(), nil
 		}
 	}
-	log.Warn("Unable to find desired quality. Falling back to best.")
+	d.logger.Warn("Unable to find desired quality. Falling back to best.")
 
 	for _, entry := range entries {
 		if d.isSupportedFormat(entry.Format) {
-			log.Debugf("Selected new quality: %s", entry.Format)
+			d.logger.Debugf("Selected new quality: %s", entry.Format)
 			return entry.testFileIDOrFileId(), nil
 		}
 	}
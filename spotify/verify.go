@@ -0,0 +1,90 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	ffmpeg "github.com/u2takey/ffmpeg-go"
+	"strconv"
+	"time"
+)
+
+// DefaultVerifyTolerance is how far the length of a verified file may be
+// from the length of the track.
+const DefaultVerifyTolerance = 2 * time.Second
+
+// probeTimeout bounds an ffprobe run, which only reads the container.
+const probeTimeout = time.Minute
+
+// ErrNoFFprobe is returned by VerifyFile when ffprobe is not installed.
+var ErrNoFFprobe = errors.New("ffprobe not found")
+
+// VerifyError reports a file that is not playable or not complete. Downloads
+// failing with it are retried, see SetRetries.
+type VerifyError struct {
+	Path   string
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("verification of [%s] failed: %s", e.Path, e.Reason)
+}
+
+type probeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		Duration  string `json:"duration"`
+	} `json:"streams"`
+}
+
+// VerifyFile probes path with ffprobe and checks that its container can be
+// read, that it has an audio stream and, if durationMS is positive, that it
+// lasts within tolerance of durationMS.
+func VerifyFile(path string, durationMS int, tolerance time.Duration) error {
+	if !hasFFprobe {
+		return ErrNoFFprobe
+	}
+	output, err := ffmpeg.ProbeWithTimeout(path, probeTimeout, nil)
+	if err != nil {
+		return &VerifyError{Path: path, Reason: fmt.Sprintf("unreadable container: %v", err)}
+	}
+	var probe probeOutput
+	if err := json.Unmarshal([]byte(output), &probe); err != nil {
+		return fmt.Errorf("failed to parse ffprobe output: %v", err)
+	}
+
+	duration := ""
+	hasAudio := false
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			hasAudio = true
+			duration = stream.Duration
+			break
+		}
+	}
+	if !hasAudio {
+		return &VerifyError{Path: path, Reason: "no audio stream"}
+	}
+	if durationMS <= 0 {
+		return nil
+	}
+
+	// Streams of some containers, such as ogg, carry no duration of their own
+	if duration == "" || duration == "N/A" {
+		duration = probe.Format.Duration
+	}
+	seconds, err := strconv.ParseFloat(duration, 64)
+	if err != nil {
+		return &VerifyError{Path: path, Reason: "unknown duration"}
+	}
+	got := time.Duration(seconds * float64(time.Second))
+	want := time.Duration(durationMS) * time.Millisecond
+	if diff := got - want; diff > tolerance || -diff > tolerance {
+		return &VerifyError{Path: path, Reason: fmt.Sprintf("duration %s, expected %s", got.Round(time.Millisecond), want)}
+	}
+	return nil
+}
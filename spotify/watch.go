@@ -0,0 +1,316 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/XiaoMengXinX/sp-dl-go/fileutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultWatchInterval is how often a watched collection is polled if its
+// WatchTarget has no interval.
+const DefaultWatchInterval = time.Hour
+
+// DefaultWatchStateName is the name of the watch state file in the output
+// folder, unless set with SetWatchState.
+const DefaultWatchStateName = "watch.json"
+
+// watchVersion is the format version of watch state files.
+const watchVersion = 1
+
+// WatchTarget is a playlist, album, show or artist polled by Watch.
+type WatchTarget struct {
+	URL      string
+	Interval time.Duration
+}
+
+// ParseWatchTarget parses a Spotify URL, URI or ID, optionally followed by
+// "@" and its own poll interval, e.g.
+// "https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M@30m".
+func ParseWatchTarget(spec string) (WatchTarget, error) {
+	target := WatchTarget{URL: spec}
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		interval, err := time.ParseDuration(spec[i+1:])
+		if err != nil {
+			return target, fmt.Errorf("invalid interval in %q: %v", spec, err)
+		}
+		if interval <= 0 {
+			return target, fmt.Errorf("interval in %q must be positive", spec)
+		}
+		target.URL, target.Interval = spec[:i], interval
+	}
+	_, idType, err := GetIDType(target.URL)
+	if err != nil {
+		return target, err
+	}
+	switch idType {
+	case PLAYLIST, ALBUM, SHOW, ARTIST:
+	default:
+		return target, fmt.Errorf("only playlists, albums, shows and artists can be watched, got %s", idType)
+	}
+	return target, nil
+}
+
+type watchState struct {
+	Version int `json:"version"`
+	// Targets are keyed by type and ID, e.g. "playlist:37i9dQ...".
+	Targets map[string]watchTargetState `json:"targets"`
+}
+
+type watchTargetState struct {
+	// SnapshotID of a playlist, and Total of the episodes of a show, the
+	// tracks of an album or the albums of an artist, tell whether it changed
+	// since the last poll.
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	Total      int    `json:"total,omitempty"`
+	// Seen are the downloaded items, or the albums of an artist.
+	Seen      []string  `json:"seen"`
+	LastCheck time.Time `json:"last_check"`
+	NextCheck time.Time `json:"next_check"`
+}
+
+// SetWatchState sets the file in which Watch keeps what it has downloaded
+// and when to poll next, so that it carries on where it stopped after a
+// restart. It is DefaultWatchStateName in the output folder if empty.
+func (d *Downloader) SetWatchState(path string) *Downloader {
+	if path != "" {
+		path = filepath.Clean(path)
+	}
+	d.watchStatePath = path
+	return d
+}
+
+func (d *Downloader) watchStateFile() string {
+	if d.watchStatePath != "" {
+		return d.watchStatePath
+	}
+	return filepath.Join(d.outputFolder, DefaultWatchStateName)
+}
+
+// Watch polls every target on its interval, or DefaultWatchInterval, and
+// downloads the items added since the previous poll, until ctx is done.
+// Collections that did not change, by playlist snapshot or number of items,
+// are skipped without listing their items.
+func (d *Downloader) Watch(ctx context.Context, targets []WatchTarget) error {
+	if len(targets) == 0 {
+		return errors.New("nothing to watch")
+	}
+	statePath := d.watchStateFile()
+	if dir := filepath.Dir(statePath); dir != "." {
+		if err := checkDirExist(dir); err != nil {
+			return err
+		}
+	}
+	// Two watchers sharing a state file would download everything twice
+	unlock, err := fileutil.Lock(statePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readWatchState(statePath)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, len(targets))
+	for i, target := range targets {
+		id, idType, err := GetIDType(target.URL)
+		if err != nil {
+			return err
+		}
+		if targets[i].Interval <= 0 {
+			targets[i].Interval = DefaultWatchInterval
+		}
+		keys[i] = fmt.Sprintf("%s:%s", idType, id)
+	}
+
+	for {
+		next := 0
+		for i := range targets {
+			if state.Targets[keys[i]].NextCheck.Before(state.Targets[keys[next]].NextCheck) {
+				next = i
+			}
+		}
+		target, key := targets[next], keys[next]
+
+		if wait := time.Until(state.Targets[key].NextCheck); wait > 0 {
+			d.logger.Debugf("Next poll of [%s] at %s", target.URL, state.Targets[key].NextCheck.Format(time.RFC3339))
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+			}
+		}
+
+		targetState := state.Targets[key]
+		if err := d.pollWatchTarget(ctx, target.URL, &targetState); err != nil {
+			d.logger.Errorf("Failed to poll [%s]: %v", target.URL, err)
+		}
+		targetState.LastCheck = time.Now().UTC()
+		targetState.NextCheck = targetState.LastCheck.Add(target.Interval)
+		state.Targets[key] = targetState
+
+		if err := writeWatchState(statePath, state); err != nil {
+			d.logger.Errorf("Failed to save watch state: %v", err)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// pollWatchTarget downloads the items of url not seen yet, and updates its
+// state. The snapshot or total is only updated once every item was
+// processed and succeeded, so that failed items, and those left when ctx
+// is done, are retried on the next poll.
+func (d *Downloader) pollWatchTarget(ctx context.Context, url string, state *watchTargetState) error {
+	id, idType, err := GetIDType(url)
+	if err != nil {
+		return err
+	}
+
+	var snapshotID string
+	var total int
+	switch idType {
+	case PLAYLIST:
+		playlist, err := d.queryPlaylistAPI(id)
+		if err != nil {
+			return err
+		}
+		snapshotID = playlist.SnapshotID
+	case SHOW:
+		show, err := d.queryShowAPI(id)
+		if err != nil {
+			return err
+		}
+		total = show.TotalEpisodes
+	case ALBUM:
+		// A cached album would hide the tracks added since it was cached.
+		album, err := d.queryAlbumAPIUncached(id)
+		if err != nil {
+			return err
+		}
+		total = album.TotalTracks
+	case ARTIST:
+		albums, err := d.queryArtistAlbumsAPI(id, 0)
+		if err != nil {
+			return err
+		}
+		total = albums.Total
+	}
+	if !state.LastCheck.IsZero() && snapshotID == state.SnapshotID && total == state.Total {
+		d.logger.Infof("%s [%s] is unchanged", idType, id)
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range state.Seen {
+		seen[item] = true
+	}
+
+	var items []HookItem
+	// complete is false if the poll stopped before processing every item
+	complete := true
+	if idType == ARTIST {
+		albums, err := d.fetchArtistAlbums(id)
+		if err != nil {
+			return err
+		}
+		for _, album := range albums {
+			if seen[album] {
+				continue
+			}
+			if ctx.Err() != nil {
+				complete = false
+				break
+			}
+			tracks, err := d.fetchAlbumTracks(album, 0, []string{})
+			if err != nil {
+				return err
+			}
+			d.logger.Infof("Downloading new album [%s] of artist [%s]", album, id)
+			albumItems := d.downloadItems(ctx, album, ALBUM, tracks)
+			d.albumReplayGain(albumItems)
+			if !hasFailed(albumItems) {
+				state.Seen = append(state.Seen, album)
+			}
+			items = append(items, albumItems...)
+		}
+	} else {
+		tracks, err := d.GetTracks(url)
+		if err != nil {
+			return err
+		}
+		var unseen []string
+		for _, track := range tracks {
+			if !seen[track] {
+				unseen = append(unseen, track)
+			}
+		}
+		d.logger.Infof("%s [%s] changed, %d new item(s)", idType, id, len(unseen))
+		items = d.downloadItems(ctx, id, idType, unseen)
+		for _, item := range items {
+			if item.Error == "" {
+				state.Seen = append(state.Seen, item.ID)
+			}
+		}
+		if idType == ALBUM && len(unseen) == len(tracks) {
+			d.albumReplayGain(items)
+		}
+	}
+
+	if complete && !hasFailed(items) {
+		state.SnapshotID, state.Total = snapshotID, total
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return d.runHooks(HookAfterBatch, HookPayload{Type: idType, ID: id, Items: items})
+}
+
+func hasFailed(items []HookItem) bool {
+	for _, item := range items {
+		if item.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func readWatchState(path string) (watchState, error) {
+	state := watchState{Targets: make(map[string]watchTargetState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("unable to parse watch state [%s]: %w", path, err)
+	}
+	if state.Version > watchVersion {
+		return state, fmt.Errorf("watch state [%s] has version %d, newer than the supported version %d", path, state.Version, watchVersion)
+	}
+	if state.Targets == nil {
+		state.Targets = make(map[string]watchTargetState)
+	}
+	return state, nil
+}
+
+func writeWatchState(path string, state watchState) error {
+	state.Version = watchVersion
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFile(path, data, 0644)
+}
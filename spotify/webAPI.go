@@ -3,8 +3,8 @@ package spotify
 import (
 	"encoding/json"
 	"fmt"
-	log "github.com/XiaoMengXinX/sp-dl-go/logger"
 	"net/http"
+	"strings"
 )
 
 func (d *Downloader) WebAPIGetTrackInfo(trackID string) (WebAPITrackInfo, error) {
@@ -16,6 +16,8 @@ func (d *Downloader) WebAPIGetTrackInfo(trackID string) (WebAPITrackInfo, error)
 	trackInfo.Name = track.Name
 	trackInfo.DurationMS = track.DurationMS
 	trackInfo.URL = track.ExternalUrls.Spotify
+	trackInfo.Explicit = track.Explicit
+	trackInfo.Popularity = track.Popularity
 	trackInfo.Artists = make([]WebAPIArtist, len(track.Artists))
 	for i, artist := range track.Artists {
 		trackInfo.Artists[i] = WebAPIArtist{
@@ -45,6 +47,16 @@ func (d *Downloader) WebAPIGetTrackInfo(trackID string) (WebAPITrackInfo, error)
 			Height: img.Height,
 		}
 	}
+	if album, err := d.queryAlbumAPI(track.Album.ID); err == nil {
+		trackInfo.Genres = d.albumGenres(album)
+	} else {
+		d.logger.Warnf("Failed to fetch album data: %v", err)
+	}
+	if credits, err := d.getTrackCredits(trackID); err == nil {
+		trackInfo.Credits = credits
+	} else {
+		d.logger.Warnf("Failed to fetch track credits: %v", err)
+	}
 	return trackInfo, nil
 }
 
@@ -52,7 +64,7 @@ func (d *Downloader) queryAlbumTracksAPI(albumID string, offset int) (albumTrack
 	url := fmt.Sprintf("https://api.spotify.com/v1/albums/%s/tracks?offset=%d&limit=50", albumID, offset)
 	data, err := d.makeRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Debugf("Fetch Album tracks Failed: %v", err)
+		d.logger.Debugf("Fetch Album tracks Failed: %v", err)
 		return albumTracksData{}, err
 	}
 
@@ -67,7 +79,7 @@ func (d *Downloader) queryPlaylistTracksAPI(playlistID string, offset int) (play
 	url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks?offset=%d&limit=100", playlistID, offset)
 	data, err := d.makeRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Debugf("Fetch Playlist tracks Failed: %v", err)
+		d.logger.Debugf("Fetch Playlist tracks Failed: %v", err)
 		return playlistTracksData{}, err
 	}
 
@@ -78,11 +90,41 @@ func (d *Downloader) queryPlaylistTracksAPI(playlistID string, offset int) (play
 	return playlistTracks, nil
 }
 
+func (d *Downloader) queryPlaylistAPI(playlistID string) (playlistData, error) {
+	url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s?fields=id,name,snapshot_id", playlistID)
+	data, err := d.makeRequest(http.MethodGet, url, nil)
+	if err != nil {
+		d.logger.Debugf("Fetch Playlist Failed: %v", err)
+		return playlistData{}, err
+	}
+
+	var playlist playlistData
+	if err := json.Unmarshal(data, &playlist); err != nil {
+		return playlistData{}, fmt.Errorf("failed to decode playlist data: %w", err)
+	}
+	return playlist, nil
+}
+
+func (d *Downloader) queryArtistAlbumsAPI(artistID string, offset int) (artistAlbumsData, error) {
+	url := fmt.Sprintf("https://api.spotify.com/v1/artists/%s/albums?include_groups=album,single&offset=%d&limit=50", artistID, offset)
+	data, err := d.makeRequest(http.MethodGet, url, nil)
+	if err != nil {
+		d.logger.Debugf("Fetch Artist albums Failed: %v", err)
+		return artistAlbumsData{}, err
+	}
+
+	var artistAlbums artistAlbumsData
+	if err := json.Unmarshal(data, &artistAlbums); err != nil {
+		return artistAlbums, fmt.Errorf("failed to decode artist albums data: %w", err)
+	}
+	return artistAlbums, nil
+}
+
 func (d *Downloader) queryShowTracksAPI(showID string, offset int) (showTracksData, error) {
 	url := fmt.Sprintf("https://api.spotify.com/v1/shows/%s/episodes?offset=%d&limit=50", showID, offset)
 	data, err := d.makeRequest(http.MethodGet, url, nil)
 	if err != nil {
-		log.Debugf("Fetch Show episodes Failed: %v", err)
+		d.logger.Debugf("Fetch Show episodes Failed: %v", err)
 		return showTracksData{}, err
 	}
 
@@ -93,11 +135,35 @@ func (d *Downloader) queryShowTracksAPI(showID string, offset int) (showTracksDa
 	return showTracks, nil
 }
 
+func (d *Downloader) queryShowAPI(showID string) (showData, error) {
+	url := fmt.Sprintf("https://api.spotify.com/v1/shows/%s", showID)
+	data, err := d.makeRequest(http.MethodGet, url, nil)
+	if err != nil {
+		d.logger.Debugf("Fetch Show Failed: %v", err)
+		return showData{}, err
+	}
+
+	var show showData
+	if err := json.Unmarshal(data, &show); err != nil {
+		return showData{}, fmt.Errorf("failed to decode show data: %w", err)
+	}
+	return show, nil
+}
+
 func (d *Downloader) queryAlbumAPI(albumID string) (albumData, error) {
+	return d.fetchAlbumAPI(albumID, d.cachedRequest)
+}
+
+// queryAlbumAPIUncached is queryAlbumAPI, which bypasses the metadata cache.
+func (d *Downloader) queryAlbumAPIUncached(albumID string) (albumData, error) {
+	return d.fetchAlbumAPI(albumID, d.uncachedRequest)
+}
+
+func (d *Downloader) fetchAlbumAPI(albumID string, request func(key, url string) ([]byte, error)) (albumData, error) {
 	url := fmt.Sprintf("https://api.spotify.com/v1/albums/%s", albumID)
-	data, err := d.makeRequest(http.MethodGet, url, nil)
+	data, err := request(d.albumCacheKey(albumID), url)
 	if err != nil {
-		log.Debugf("Fetch Album Failed: %v", err)
+		d.logger.Debugf("Fetch Album Failed: %v", err)
 		return albumData{}, err
 	}
 
@@ -110,9 +176,9 @@ func (d *Downloader) queryAlbumAPI(albumID string) (albumData, error) {
 
 func (d *Downloader) queryTrackAPI(trackID string) (trackData, error) {
 	url := fmt.Sprintf("https://api.spotify.com/v1/tracks/%s", trackID)
-	data, err := d.makeRequest(http.MethodGet, url, nil)
+	data, err := d.cachedRequest(d.trackCacheKey(trackID), url)
 	if err != nil {
-		log.Debugf("Fetch Track Failed: %v", err)
+		d.logger.Debugf("Fetch Track Failed: %v", err)
 		return trackData{}, err
 	}
 
@@ -122,3 +188,112 @@ func (d *Downloader) queryTrackAPI(trackID string) (trackData, error) {
 	}
 	return track, nil
 }
+
+func (d *Downloader) queryTracksAPI(trackIDs []string) ([]trackData, error) {
+	url := fmt.Sprintf("https://api.spotify.com/v1/tracks?ids=%s", strings.Join(trackIDs, ","))
+	data, err := d.makeRequest(http.MethodGet, url, nil)
+	if err != nil {
+		d.logger.Debugf("Fetch Tracks Failed: %v", err)
+		return nil, err
+	}
+
+	var response struct {
+		Tracks []json.RawMessage `json:"tracks"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode tracks data: %w", err)
+	}
+
+	tracks := make([]trackData, 0, len(response.Tracks))
+	for _, raw := range response.Tracks {
+		var track trackData
+		if err := json.Unmarshal(raw, &track); err != nil || track.ID == "" {
+			continue
+		}
+		d.metadataCache.set(d.trackCacheKey(track.ID), raw)
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+func (d *Downloader) queryAlbumsAPI(albumIDs []string) ([]albumData, error) {
+	url := fmt.Sprintf("https://api.spotify.com/v1/albums?ids=%s", strings.Join(albumIDs, ","))
+	data, err := d.makeRequest(http.MethodGet, url, nil)
+	if err != nil {
+		d.logger.Debugf("Fetch Albums Failed: %v", err)
+		return nil, err
+	}
+
+	var response struct {
+		Albums []json.RawMessage `json:"albums"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode albums data: %w", err)
+	}
+
+	albums := make([]albumData, 0, len(response.Albums))
+	for _, raw := range response.Albums {
+		var album albumData
+		if err := json.Unmarshal(raw, &album); err != nil || album.ID == "" {
+			continue
+		}
+		d.metadataCache.set(d.albumCacheKey(album.ID), raw)
+		albums = append(albums, album)
+	}
+	return albums, nil
+}
+
+func (d *Downloader) queryArtistsAPI(artistIDs []string) ([]artistDetailData, error) {
+	var artists []artistDetailData
+	var missing []string
+	for _, id := range artistIDs {
+		if data, ok := d.metadataCache.get(d.artistCacheKey(id)); ok {
+			var artist artistDetailData
+			if err := json.Unmarshal(data, &artist); err == nil {
+				artists = append(artists, artist)
+				continue
+			}
+		}
+		missing = append(missing, id)
+	}
+
+	for _, ids := range chunkIDs(missing, 50) {
+		url := fmt.Sprintf("https://api.spotify.com/v1/artists?ids=%s", strings.Join(ids, ","))
+		data, err := d.makeRequest(http.MethodGet, url, nil)
+		if err != nil {
+			d.logger.Debugf("Fetch Artists Failed: %v", err)
+			return nil, err
+		}
+
+		var response struct {
+			Artists []json.RawMessage `json:"artists"`
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return nil, fmt.Errorf("failed to decode artists data: %w", err)
+		}
+		for _, raw := range response.Artists {
+			var artist artistDetailData
+			if err := json.Unmarshal(raw, &artist); err != nil || artist.ID == "" {
+				continue
+			}
+			d.metadataCache.set(d.artistCacheKey(artist.ID), raw)
+			artists = append(artists, artist)
+		}
+	}
+	return artists, nil
+}
+
+func (d *Downloader) queryTrackCredits(trackID string) (trackCreditsData, error) {
+	url := fmt.Sprintf("https://spclient.wg.spotify.com/track-credits-view/v0/experimental/%s/credits", trackID)
+	data, err := d.cachedRequest(d.creditsCacheKey(trackID), url)
+	if err != nil {
+		d.logger.Debugf("Fetch Track Credits Failed: %v", err)
+		return trackCreditsData{}, err
+	}
+
+	var credits trackCreditsData
+	if err := json.Unmarshal(data, &credits); err != nil {
+		return trackCreditsData{}, fmt.Errorf("failed to decode track credits: %w", err)
+	}
+	return credits, nil
+}
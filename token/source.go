@@ -0,0 +1,157 @@
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/XiaoMengXinX/sp-dl-go/logger"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is an access token for the Spotify APIs. A zero Expiry means the
+// expiry is unknown and the token is used until the API rejects it.
+type Token struct {
+	AccessToken string
+	Expiry      time.Time
+}
+
+// Valid reports whether t is non-empty and not expired.
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Before(t.Expiry))
+}
+
+// TokenSource supplies access tokens, in the manner of oauth2.TokenSource.
+// Implementations must be safe for concurrent use.
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+// Invalidator is implemented by token sources that can drop a token after
+// the API rejected it, so that the next call to Token returns a new one.
+type Invalidator interface {
+	Invalidate(accessToken string)
+}
+
+// Token returns an access token obtained with the sp_dc cookie.
+func (tm *Manager) Token() (*Token, error) {
+	accessToken, err := tm.GetAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	return &Token{AccessToken: accessToken, Expiry: tm.AccessTokenExpiry()}, nil
+}
+
+type staticTokenSource struct {
+	token *Token
+}
+
+// StaticTokenSource always returns the same token, which is useful in tests
+// and short scripts that already hold a token.
+func StaticTokenSource(accessToken string) TokenSource {
+	log.AddSecret(accessToken)
+	return staticTokenSource{token: &Token{AccessToken: accessToken}}
+}
+
+func (s staticTokenSource) Token() (*Token, error) {
+	return s.token, nil
+}
+
+type fileTokenSource struct {
+	path string
+}
+
+// FileTokenSource reads the token from path on every call, so that another
+// process can keep the file up to date. The file holds either the bare
+// token or a JSON object in the format of Spotify's get_access_token
+// response.
+func FileTokenSource(path string) TokenSource {
+	return fileTokenSource{path: path}
+}
+
+func (s fileTokenSource) Token() (*Token, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	return parseToken(data)
+}
+
+type commandTokenSource struct {
+	name string
+	args []string
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// CommandTokenSource runs a command and reads the token from its standard
+// output, in the same formats as FileTokenSource. The token is reused until
+// it expires or is invalidated.
+func CommandTokenSource(name string, args ...string) TokenSource {
+	return &commandTokenSource{name: name, args: args}
+}
+
+func (s *commandTokenSource) Token() (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Valid() {
+		return s.token, nil
+	}
+
+	log.Debugf("Running token command: %s", s.name)
+	var stderr bytes.Buffer
+	cmd := exec.Command(s.name, s.args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("token command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	token, err := parseToken(out)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+func (s *commandTokenSource) Invalidate(accessToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != nil && s.token.AccessToken == accessToken {
+		s.token = nil
+	}
+}
+
+func parseToken(data []byte) (*Token, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty access token")
+	}
+
+	token := &Token{AccessToken: string(data)}
+	if data[0] == '{' {
+		var response struct {
+			AccessToken string `json:"accessToken"`
+			ExpireMs    int64  `json:"accessTokenExpirationTimestampMs"`
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return nil, fmt.Errorf("unable to parse token: %w", err)
+		}
+		if response.AccessToken == "" {
+			return nil, errors.New("no access token in token data")
+		}
+		token.AccessToken = response.AccessToken
+		if response.ExpireMs > 0 {
+			token.Expiry = time.UnixMilli(response.ExpireMs)
+		}
+	}
+	log.AddSecret(token.AccessToken)
+	return token, nil
+}
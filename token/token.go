@@ -2,129 +2,332 @@ package token
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/XiaoMengXinX/sp-dl-go/config"
+	"github.com/XiaoMengXinX/sp-dl-go/credential"
 	log "github.com/XiaoMengXinX/sp-dl-go/logger"
 	"io"
+	"log/slog"
 	"net/http"
-	"os"
+	"sync"
 	"time"
 )
 
+var (
+	// ErrNoSpDc is returned by QuerySpDc when no cookie is available and the
+	// manager may not prompt for one.
+	ErrNoSpDc = errors.New("sp_dc cookie not found; provide it with $SPDL_SP_DC, a cookie file or stdin, or run the login command")
+	// ErrInvalidSpDc is returned when Spotify treats the cookie as anonymous.
+	ErrInvalidSpDc = errors.New("invalid sp_dc cookie")
+)
+
+// requestTimeout bounds the access token request, which is made while
+// holding the manager and credential locks.
+const requestTimeout = 30 * time.Second
+
+// Manager hands out access tokens obtained with the sp_dc cookie. It is safe
+// for concurrent use: the token is cached in memory, and only one caller
+// refreshes it while the others wait for the result.
 type Manager struct {
-	TokenURL          string
-	SpDc              string
-	AccessToken       string
-	AccessTokenExpire int64
-	ConfigManager     *config.Manager
+	TokenURL        string
+	SpDc            string
+	ConfigManager   *config.Manager
+	CredentialStore credential.Store
+
+	// NonInteractive disables prompting on the terminal for a missing cookie.
+	NonInteractive bool
+	// RefreshMargin is how long before its expiry the access token is renewed.
+	RefreshMargin time.Duration
+	// Logger receives the log output of the manager. The logger package is
+	// used if it is nil.
+	Logger *slog.Logger
+
+	mu                sync.Mutex
+	loaded            bool
+	accessToken       string
+	accessTokenExpire int64
 }
 
 func NewTokenManager() *Manager {
 	log.Debugln("New Token Manager Created")
 	return &Manager{
-		TokenURL:      "https://open.spotify.com/get_access_token",
-		ConfigManager: config.NewConfigManager(),
+		TokenURL:        "https://open.spotify.com/get_access_token",
+		ConfigManager:   config.NewConfigManager(),
+		CredentialStore: credential.NewFileStore("credentials.json"),
+		RefreshMargin:   time.Minute,
 	}
 }
 
-func (tm *Manager) QuerySpDc() {
-	log.Debugln("Querying sp_dc cookie")
-	conf, err := tm.ConfigManager.ReadAndGet()
+func (tm *Manager) logger() *log.Logger {
+	return log.New(tm.Logger)
+}
+
+// QuerySpDc loads the sp_dc cookie and a valid access token. A cookie set in
+// SpDc beforehand takes precedence over the stored one and replaces it.
+func (tm *Manager) QuerySpDc() error {
+	tm.logger().Debugln("Querying sp_dc cookie")
+	if err := tm.migrateLegacyCredentials(); err != nil {
+		return err
+	}
+
+	creds, err := tm.CredentialStore.Load()
 	if err != nil {
-		log.Warnf("Failed to read config: %v", err)
+		return fmt.Errorf("failed to load credentials: %w", err)
 	}
-	if conf.SpDc == "" {
+	switch {
+	case tm.SpDc != "" && tm.SpDc != creds.SpDc:
+		tm.logger().Debugln("Using provided sp_dc cookie")
+		creds = credential.Credentials{SpDc: tm.SpDc}
+	case creds.SpDc != "":
+		tm.logger().Debugln("sp_dc cookie found in credential store")
+		tm.SpDc = creds.SpDc
+	case tm.NonInteractive:
+		return ErrNoSpDc
+	default:
+		tm.logger().Warnln("sp_dc cookie not found, prompting user input")
+		fmt.Print("sp_dc: ")
+		_, _ = fmt.Scanln(&tm.SpDc)
 		if tm.SpDc == "" {
-			log.Warnln("sp_dc cookie not found, prompting user input")
-			fmt.Print("sp_dc: ")
-			_, _ = fmt.Scanln(&tm.SpDc)
-			conf.SpDc = tm.SpDc
+			return ErrNoSpDc
+		}
+		creds = credential.Credentials{SpDc: tm.SpDc}
+	}
+	log.AddSecret(tm.SpDc)
+
+	if creds.AccessToken == "" {
+		if err := tm.CredentialStore.Save(creds); err != nil {
+			return fmt.Errorf("failed to save credentials: %w", err)
 		}
-		conf.SpDc = tm.SpDc
-		tm.ConfigManager.Set(conf)
-		log.Debugln("sp_dc cookie saved to config")
-	} else {
-		log.Debugln("sp_dc cookie found in config")
-		tm.SpDc = conf.SpDc
+		tm.logger().Debugln("sp_dc cookie saved to credential store")
+	}
+
+	tm.mu.Lock()
+	tm.accessToken, tm.accessTokenExpire, tm.loaded = creds.AccessToken, creds.AccessTokenExpire, true
+	tm.mu.Unlock()
+
+	_, err = tm.GetAccessToken()
+	return err
+}
+
+// Login checks that spDc is accepted by Spotify by requesting an access
+// token, and only then saves it to the credential store.
+func (tm *Manager) Login(spDc string) error {
+	log.AddSecret(spDc)
+	token, expire, err := tm._requestAccessToken(spDc)
+	if err != nil {
+		return err
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.SpDc, tm.accessToken, tm.accessTokenExpire, tm.loaded = spDc, token, expire, true
+	return tm.persist()
+}
+
+// migrateLegacyCredentials moves credentials stored in the config file by
+// older versions into the credential store.
+func (tm *Manager) migrateLegacyCredentials() error {
+	conf, err := tm.ConfigManager.ReadAndGet()
+	if err != nil {
+		tm.logger().Warnf("Failed to read config: %v", err)
+		return nil
+	}
+	if conf.LegacySpDc == "" && conf.LegacyAccessToken == "" {
+		return nil
 	}
 
-	tm.AccessToken, tm.AccessTokenExpire = tm.GetAccessToken()
+	return tm.ConfigManager.Update(func(conf *config.Data) error {
+		if conf.LegacySpDc == "" && conf.LegacyAccessToken == "" {
+			// moved by another process in the meantime
+			return nil
+		}
+		unlock, err := tm.lockCredentials()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		creds, err := tm.CredentialStore.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load credentials: %w", err)
+		}
+		if creds.SpDc == "" {
+			creds = credential.Credentials{
+				SpDc:              conf.LegacySpDc,
+				AccessToken:       conf.LegacyAccessToken,
+				AccessTokenExpire: conf.LegacyAccessTokenExpire,
+			}
+			if err := tm.CredentialStore.Save(creds); err != nil {
+				return fmt.Errorf("failed to save credentials: %w", err)
+			}
+		}
+
+		conf.LegacySpDc, conf.LegacyAccessToken, conf.LegacyAccessTokenExpire = "", "", 0
+		tm.logger().Infoln("Moved credentials from the config file to the credential store")
+		return nil
+	})
+}
+
+// lockCredentials locks the credential store against other processes if it
+// supports locking.
+func (tm *Manager) lockCredentials() (unlock func() error, err error) {
+	locker, ok := tm.CredentialStore.(credential.Locker)
+	if !ok {
+		return func() error { return nil }, nil
+	}
+	unlock, err = locker.Lock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock credentials: %w", err)
+	}
+	return unlock, nil
 }
 
 func (tm *Manager) _requestAccessToken(spDc string) (string, int64, error) {
-	log.Debugln("Requesting access token from Spotify")
-	client := &http.Client{}
+	tm.logger().Debugln("Requesting access token from Spotify")
+	client := &http.Client{Timeout: requestTimeout}
 
 	req, err := http.NewRequest("GET", tm.TokenURL, nil)
 	if err != nil {
-		log.Errorf("Unable to create HTTP request: %v", err)
+		tm.logger().Errorf("Unable to create HTTP request: %v", err)
 		return "", -1, fmt.Errorf("unable to create request: %w", err)
 	}
 
 	req.Header.Set("Cookie", fmt.Sprintf("sp_dc=%s", spDc))
-	log.Debugf("Sending request to %s with sp_dc: %s", tm.TokenURL, spDc)
+	tm.logger().Debugf("Sending request to %s", tm.TokenURL)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Errorf("Error sending request: %v", err)
+		tm.logger().Errorf("Error sending request: %v", err)
 		return "", -1, fmt.Errorf("unable to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	log.Debugln("Received response with status code: %d", resp.StatusCode)
+	tm.logger().Debugf("Received response with status code: %d", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("Failed to request token (status %d): %s", resp.StatusCode, string(body))
+		return "", -1, fmt.Errorf("failed to request token (status %d): %s", resp.StatusCode, string(body))
 	}
 
 	var tokenResponse map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
-		log.Errorf("Error parsing token response: %v", err)
+		tm.logger().Errorf("Error parsing token response: %v", err)
 		return "", -1, fmt.Errorf("unable to parse token response: %w", err)
 	}
 
-	log.Debugf("Token response: %+v", tokenResponse)
+	if accessToken, ok := tokenResponse["accessToken"].(string); ok {
+		log.AddSecret(accessToken)
+	}
+	tm.logger().Debugf("Token response: %+v", tokenResponse)
 
 	if isAnonymous, ok := tokenResponse["isAnonymous"].(bool); ok && isAnonymous {
-		log.Fatal("Invalid sp_dc cookie, forcing config reset")
-		tm.ConfigManager.Set(config.Data{})
-		os.Exit(1)
+		return "", -1, ErrInvalidSpDc
 	}
 
-	accessToken := tokenResponse["accessToken"].(string)
-	expireTimestamp := int64(tokenResponse["accessTokenExpirationTimestampMs"].(float64))
-
-	conf, _ := tm.ConfigManager.ReadAndGet()
-	conf.AccessToken = accessToken
-	conf.AccessTokenExpire = expireTimestamp
-	tm.ConfigManager.Set(conf)
+	accessToken, _ := tokenResponse["accessToken"].(string)
+	expireTimestampMs, _ := tokenResponse["accessTokenExpirationTimestampMs"].(float64)
+	if accessToken == "" {
+		return "", -1, fmt.Errorf("no access token in token response")
+	}
+	expireTimestamp := int64(expireTimestampMs)
 
-	log.Debugln("Access token successfully retrieved and saved to config")
+	tm.logger().Debugln("Access token successfully retrieved")
 	return accessToken, expireTimestamp, nil
 }
 
-func (tm *Manager) GetAccessToken() (string, int64) {
-	log.Debugln("Checking access token")
+// GetAccessToken returns the cached access token, refreshing it first if it
+// expires within RefreshMargin.
+func (tm *Manager) GetAccessToken() (string, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
 
-	conf, err := tm.ConfigManager.ReadAndGet()
+	if !tm.loaded {
+		creds, err := tm.CredentialStore.Load()
+		if err != nil {
+			return "", fmt.Errorf("error reading credentials: %w", err)
+		}
+		if tm.SpDc == "" {
+			tm.SpDc = creds.SpDc
+		}
+		tm.accessToken, tm.accessTokenExpire, tm.loaded = creds.AccessToken, creds.AccessTokenExpire, true
+	}
+
+	refreshAt := time.UnixMilli(tm.accessTokenExpire).Add(-tm.RefreshMargin)
+	if tm.accessToken != "" && time.Now().Before(refreshAt) {
+		return tm.accessToken, nil
+	}
+
+	tm.logger().Debugf("Access token expires at %d, requesting new token", tm.accessTokenExpire)
+	if err := tm.refresh(); err != nil {
+		return "", fmt.Errorf("error requesting new token: %w", err)
+	}
+	return tm.accessToken, nil
+}
+
+// Invalidate discards token after the API rejected it, so that the next call
+// to GetAccessToken fetches a new one. Stale tokens are ignored, so several
+// requests failing with the same token cause a single refresh.
+func (tm *Manager) Invalidate(token string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if token == tm.accessToken {
+		tm.logger().Debugln("Access token rejected, invalidating it")
+		tm.accessTokenExpire = 0
+	}
+}
+
+// AccessTokenExpiry returns when the cached access token expires.
+func (tm *Manager) AccessTokenExpiry() time.Time {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return time.UnixMilli(tm.accessTokenExpire)
+}
+
+// refresh must be called with tm.mu held. The credential store stays locked
+// until the new token is saved, and a token saved by another process in the
+// meantime is used instead of requesting one more.
+func (tm *Manager) refresh() error {
+	unlock, err := tm.lockCredentials()
 	if err != nil {
-		log.Fatalf("Error reading config: %v", err)
+		return err
 	}
+	defer unlock()
 
-	currentTime := time.Now().UnixNano() / 1e6
-	log.Debugf("Current time (ms): %d, Token expiration time: %d", currentTime, conf.AccessTokenExpire)
+	creds, err := tm.CredentialStore.Load()
+	if err == nil && creds.SpDc == tm.SpDc && creds.AccessToken != "" && creds.AccessToken != tm.accessToken &&
+		time.Now().Before(time.UnixMilli(creds.AccessTokenExpire).Add(-tm.RefreshMargin)) {
+		tm.logger().Debugln("Using access token refreshed by another process")
+		tm.accessToken, tm.accessTokenExpire = creds.AccessToken, creds.AccessTokenExpire
+		return nil
+	}
 
-	if currentTime >= conf.AccessTokenExpire {
-		log.Warnln("Access token expired, requesting new token")
-		token, expire, err := tm._requestAccessToken(tm.SpDc)
-		if err != nil {
-			log.Fatalf("Error requesting new token: %v", err)
-		}
-		log.Debugln("New access token obtained")
-		return token, expire
+	token, expire, err := tm._requestAccessToken(tm.SpDc)
+	if errors.Is(err, ErrInvalidSpDc) {
+		tm.logger().Errorln("Invalid sp_dc cookie, forcing credential reset")
+		_ = tm.CredentialStore.Save(credential.Credentials{})
+	}
+	if err != nil {
+		return err
 	}
 
-	log.Debugln("Using cached access token")
-	return conf.AccessToken, conf.AccessTokenExpire
+	changed := token != tm.accessToken || expire != tm.accessTokenExpire
+	tm.accessToken, tm.accessTokenExpire = token, expire
+	if !changed {
+		return nil
+	}
+	if err := tm.persist(); err != nil {
+		tm.logger().Warnf("Failed to save access token: %v", err)
+	}
+	return nil
+}
+
+// persist must be called with tm.mu held.
+func (tm *Manager) persist() error {
+	tm.logger().Debugln("Saving access token to credential store")
+	return tm.CredentialStore.Save(credential.Credentials{
+		SpDc:              tm.SpDc,
+		AccessToken:       tm.accessToken,
+		AccessTokenExpire: tm.accessTokenExpire,
+	})
 }